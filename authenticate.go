@@ -3,6 +3,7 @@ package jwtauth
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/goadesign/goa"
@@ -19,36 +20,118 @@ import (
 // so that the authentication claims become available to your authorization
 // middleware(s) that implement your security schemes.
 func Authenticate(scheme *goa.JWTSecurity, store Keystore) goa.Middleware {
-	return AuthenticateWithFunc(scheme, store, DefaultExtraction)
+	return AuthenticateWithOptions(scheme, store)
 }
 
 // AuthenticateWithFunc creates an authentication middleware that uses a
 // custom ExtractionFunc.
 func AuthenticateWithFunc(scheme *goa.JWTSecurity, store Keystore, extraction ExtractionFunc) goa.Middleware {
+	return AuthenticateWithOptions(scheme, store, Extraction(extraction))
+}
+
+// AuthenticateWithOptions creates an authentication middleware whose
+// behavior is customized by options such as Extraction and Introspection.
+// With no options, it behaves exactly like Authenticate.
+func AuthenticateWithOptions(scheme *goa.JWTSecurity, store Keystore, opts ...Option) goa.Middleware {
+	o := &options{extraction: DefaultExtraction}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.cache != nil {
+		o.cache.watch(store)
+	}
+
 	return func(nextHandler goa.Handler) goa.Handler {
 		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
-			token, err := parseToken(scheme, store, extraction, req)
+			rawToken, err := o.extraction(scheme, req)
+			if err != nil {
+				return err
+			}
+
+			claims, tenant, err := o.authenticate(ctx, store, rawToken)
 			if err != nil {
 				return err
 			}
 
-			var (
-				claims   Claims
-				rawToken string
-			)
-			if token != nil {
-				rawToken = token.Raw
-
-				if token.Claims != nil {
-					// NB: jwt-go always produces MapClaims on parse; type assertion should
-					// never fail, and if it were to, we'd want to panic since we count this
-					// as an invariant!
-					claims = Claims(token.Claims.(jwt.MapClaims))
+			if o.mtls && rawToken != "" {
+				if err := checkCertificateBinding(req, claims, o.mtlsRequired); err != nil {
+					return err
+				}
+			}
+
+			if o.dpop != nil && rawToken != "" {
+				authScheme := strings.SplitN(req.Header.Get(scheme.Name), " ", 2)[0]
+				key, err := o.dpop.verify(authScheme, req, rawToken, claims)
+				if err != nil {
+					return err
+				}
+				if key != nil {
+					ctx = WithDPoPKey(ctx, key)
 				}
 			}
 
 			ctx = WithToken(WithClaims(ctx, claims), rawToken)
+			if tenant != "" {
+				ctx = WithTenant(ctx, tenant)
+			}
 			return nextHandler(ctx, rw, req)
 		}
 	}
 }
+
+// authenticate verifies a token and returns its claims, plus the tenant of
+// its issuer (if any). It returns no claims and no error if the token is
+// empty.
+func (o *options) authenticate(ctx context.Context, store Keystore, rawToken string) (Claims, string, error) {
+	if rawToken == "" {
+		return nil, "", nil
+	}
+
+	var claims Claims
+	var tenant string
+	encrypted := o.decryption != nil && isJWE(rawToken)
+	if o.introspector != nil && !encrypted && !isJWT(rawToken) {
+		var err error
+		if claims, err = o.introspector.Introspect(ctx, rawToken); err != nil {
+			return nil, "", err
+		}
+	} else if cached, tnt, ok := o.cache.get(rawToken); ok {
+		claims, tenant = cached, tnt
+	} else {
+		generation := o.cache.snapshot()
+		signed := rawToken
+		if encrypted {
+			payload, err := o.decryption.decrypt(rawToken)
+			if err != nil {
+				return nil, "", err
+			}
+			if signed = string(payload); !isJWT(signed) {
+				return nil, "", ErrInvalidToken("Encrypted token does not contain a signed JWT")
+			}
+		}
+
+		token, tnt, err := parseToken(store, signed)
+		if err != nil {
+			return nil, "", err
+		}
+		if isRefreshToken(token) {
+			return nil, "", ErrInvalidToken("Refresh tokens cannot be used for authentication")
+		}
+		if token.Claims != nil {
+			// NB: jwt-go always produces MapClaims on parse; type assertion should
+			// never fail, and if it were to, we'd want to panic since we count this
+			// as an invariant!
+			claims = Claims(token.Claims.(jwt.MapClaims))
+		}
+		tenant = tnt
+		o.cache.add(rawToken, claims, tenant, generation)
+	}
+
+	if o.revocation != nil {
+		if err := checkRevocation(o.revocation, claims); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return claims, tenant, nil
+}
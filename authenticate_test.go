@@ -40,6 +40,39 @@ var _ = Describe("Authenticate() middleware", func() {
 			result := middleware(stack)(context.Background(), resp, req)
 
 			Ω(result).Should(HaveResponseStatus(401))
+			Ω(result).ShouldNot(HaveMetaKey("fingerprint"))
+		})
+
+		It("does not reveal fingerprints of HMAC keys", func() {
+			setBearerHeader(req, makeToken("alice", "bob", hmacKey2))
+
+			result := stack(context.Background(), resp, req)
+
+			Ω(result).Should(HaveResponseStatus(401))
+			Ω(result).ShouldNot(HaveMetaKey("fingerprint"))
+		})
+
+		It("rejects tokens whose alg does not suit the issuer's key", func() {
+			store := &jwtauth.SimpleKeystore{rsaKey1.Public()}
+			middleware := jwtauth.Authenticate(commonScheme, store)
+
+			setBearerHeader(req, makeToken("alice", "bob", hmacKey1))
+
+			var result error
+			Ω(func() { result = middleware(stack)(context.Background(), resp, req) }).ShouldNot(Panic())
+			Ω(result).Should(HaveResponseStatus(401))
+		})
+
+		It("uses a custom extraction function", func() {
+			extraction := func(*goa.JWTSecurity, *http.Request) (string, error) {
+				return makeToken("alice", "bob", hmacKey1), nil
+			}
+			middleware := jwtauth.AuthenticateWithOptions(commonScheme, &jwtauth.SimpleKeystore{hmacKey1},
+				jwtauth.Extraction(extraction))
+
+			result := middleware(stack)(context.Background(), resp, req)
+
+			Ω(result).ShouldNot(HaveOccurred())
 		})
 
 		It("fails when JWTSecurity.Location is unsupported", func() {
@@ -116,9 +149,88 @@ var _ = Describe("Authenticate() middleware", func() {
 
 	})
 
+	Context("given an issuer with several keys", func() {
+		var resp *httptest.ResponseRecorder
+		var req *http.Request
+		var stack goa.Handler
+		var claims jwtauth.Claims
+		var tenant string
+
+		BeforeEach(func() {
+			resp = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "http://example.com/", nil)
+			stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				claims = jwtauth.ContextClaims(ctx)
+				tenant = jwtauth.ContextTenant(ctx)
+				return nil
+			}
+		})
+
+		It("tries every compatible key", func() {
+			store := &jwtauth.NamedKeystore{}
+			Ω(store.Trust("alice", []interface{}{hmacKey2, rsaKey2, rsaKey1})).Should(Succeed())
+			middleware := jwtauth.Authenticate(commonScheme, store)
+
+			setBearerHeader(req, makeToken("alice", "bob", rsaKey1))
+			Ω(middleware(stack)(context.Background(), resp, req)).ShouldNot(HaveOccurred())
+			Ω(claims.String("sub")).Should(Equal("bob"))
+
+			setBearerHeader(req, makeToken("alice", "bob", ecKey1))
+			Ω(middleware(stack)(context.Background(), resp, req)).Should(HaveResponseStatus(401))
+		})
+
+		It("selects keys from a JWK set by kid", func() {
+			set := &jwtauth.JWKSet{Keys: []*jwtauth.JWK{
+				{Key: rsaKey1, KeyID: "one"},
+				{Key: rsaKey2, KeyID: "two"},
+			}}
+			store := &jwtauth.NamedKeystore{}
+			Ω(store.Trust("alice", set)).Should(Succeed())
+			middleware := jwtauth.Authenticate(commonScheme, store)
+
+			token := jwtpkg.NewWithClaims(jwtpkg.SigningMethodRS256, jwtpkg.MapClaims{"iss": "alice", "sub": "bob"})
+			token.Header["kid"] = "two"
+			s, err := token.SignedString(rsaKey2)
+			Ω(err).NotTo(HaveOccurred())
+
+			setBearerHeader(req, s)
+			Ω(middleware(stack)(context.Background(), resp, req)).ShouldNot(HaveOccurred())
+
+			token.Header["kid"] = "one"
+			s, err = token.SignedString(rsaKey2)
+			Ω(err).NotTo(HaveOccurred())
+
+			setBearerHeader(req, s)
+			result := middleware(stack)(context.Background(), resp, req)
+			Ω(result).Should(HaveResponseStatus(401))
+			Ω(result.(*goa.ErrorResponse).Meta["fingerprint"]).Should(Equal(jwtauth.Fingerprint(rsaKey1)))
+
+			token.Header["kid"] = "three"
+			s, err = token.SignedString(rsaKey2)
+			Ω(err).NotTo(HaveOccurred())
+
+			setBearerHeader(req, s)
+			result = middleware(stack)(context.Background(), resp, req)
+			Ω(result).Should(HaveResponseStatus(401))
+			Ω(result.(*goa.ErrorResponse).Meta["fingerprint"]).Should(Equal(jwtauth.Fingerprint(set)))
+		})
+
+		It("adds the tenant to the context", func() {
+			store := &jwtauth.NamedKeystore{}
+			Ω(store.TrustPattern("https://login.example.com/*/v2.0", []interface{}{rsaKey1, rsaKey2})).Should(Succeed())
+			middleware := jwtauth.Authenticate(commonScheme, store)
+
+			setBearerHeader(req, makeToken("https://login.example.com/acme/v2.0", "bob", rsaKey2))
+			Ω(middleware(stack)(context.Background(), resp, req)).ShouldNot(HaveOccurred())
+			Ω(tenant).Should(Equal("acme"))
+		})
+	})
+
 	testKeyType("HMAC", hmacKey1, hmacKey2)
 	testKeyType("RSA", rsaKey1, rsaKey2)
 	testKeyType("ECDSA", ecKey1, ecKey2)
+	testKeyType("ECDSA P-384", ecKey384a, ecKey384b)
+	testKeyType("EdDSA", edKey1, edKey2)
 })
 
 // testKeyType defines test cases that are repeated for every supported key
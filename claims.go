@@ -180,3 +180,26 @@ func (c Claims) NotBefore() time.Time {
 func (c Claims) ExpiresAt() time.Time {
 	return c.Time("exp")
 }
+
+// Actors returns the delegation chain recorded in the "act" claim (RFC 8693
+// Section 4.1): the subject of the current actor, followed by the subjects of
+// prior actors, most recent first. It returns nil if the token was not
+// delegated.
+func (c Claims) Actors() []string {
+	var chain []string
+	act := c["act"]
+	for act != nil {
+		var next Claims
+		switch at := act.(type) {
+		case Claims:
+			next = at
+		case map[string]interface{}:
+			next = Claims(at)
+		default:
+			return chain
+		}
+		chain = append(chain, next.Subject())
+		act = next["act"]
+	}
+	return chain
+}
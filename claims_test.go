@@ -138,4 +138,18 @@ var _ = Describe("Claims", func() {
 		Expect(claims.NotBefore()).To(Equal(epoch))
 		Expect(claims.ExpiresAt()).To(Equal(then.UTC()))
 	})
+
+	It("returns the delegation chain", func() {
+		claims := jwtauth.Claims{"sub": "alice"}
+		Expect(claims.Actors()).To(BeNil())
+
+		claims["act"] = map[string]interface{}{
+			"sub": "svc-b",
+			"act": map[string]interface{}{"sub": "svc-a"},
+		}
+		Expect(claims.Actors()).To(Equal([]string{"svc-b", "svc-a"}))
+
+		claims["act"] = jwtauth.Claims{"sub": "svc-c", "act": claims["act"]}
+		Expect(claims.Actors()).To(Equal([]string{"svc-c", "svc-b", "svc-a"}))
+	})
 })
@@ -0,0 +1,138 @@
+package jwtauth
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type (
+	// CompositeKeystore is a Keystore that combines several other keystores,
+	// e.g. a SimpleKeystore for internal services, a DirectoryKeystore for
+	// partners and a NamedKeystore for everyone else.
+	//
+	// Get consults Keystores in priority order and returns the first key it
+	// finds. If the issuer begins with one of the prefixes in Routes, however,
+	// only the keystore with the longest matching prefix is consulted; this
+	// prevents an issuer from being satisfied by a key that belongs to some
+	// other trust domain.
+	//
+	// Trust and RevokeTrust are delegated to Writable. If Writable is nil,
+	// Trust returns an error and RevokeTrust has no effect. Writable need not
+	// be listed in Keystores; if it is not, Get consults it after them, so
+	// that keys trusted through the composite are honoured.
+	//
+	// CompositeKeystore is safe for concurrent use as long as its fields are
+	// not modified after it is put into service.
+	CompositeKeystore struct {
+		Keystores []Keystore
+		Writable  Keystore
+		Routes    map[string]Keystore
+	}
+)
+
+// Trust implements jwtauth.Keystore#Trust
+func (ck *CompositeKeystore) Trust(issuer string, key interface{}) error {
+	if ck.Writable == nil {
+		return fmt.Errorf("cannot trust additional keys; no writable keystore")
+	}
+	return ck.Writable.Trust(issuer, key)
+}
+
+// RevokeTrust implements jwtauth.Keystore#RevokeTrust
+func (ck *CompositeKeystore) RevokeTrust(issuer string) {
+	if ck.Writable != nil {
+		ck.Writable.RevokeTrust(issuer)
+	}
+}
+
+// Subscribe registers a function to be notified of the events emitted by
+// every member keystore that supports subscriptions (e.g. NamedKeystore and
+// DirectoryKeystore), and returns a function that cancels all of the
+// subscriptions. Keystores that are added to the composite afterwards are
+// not covered.
+func (ck *CompositeKeystore) Subscribe(fn EventFunc) (unsubscribe func()) {
+	members := append([]Keystore{ck.Writable}, ck.Keystores...)
+	for _, ks := range ck.Routes {
+		members = append(members, ks)
+	}
+
+	var cancels []func()
+	var subscribed []Keystore
+	for _, ks := range members {
+		s, ok := ks.(subscriber)
+		if !ok || containsKeystore(subscribed, ks) {
+			continue
+		}
+		subscribed = append(subscribed, ks)
+		cancels = append(cancels, s.Subscribe(fn))
+	}
+
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// Get implements jwtauth.Keystore#Get
+func (ck *CompositeKeystore) Get(issuer string) interface{} {
+	key, _ := ck.Match(issuer)
+	return key
+}
+
+// Match is like Get, but also returns the issuer's tenant if the keystore
+// that supplied the key matched the issuer against a pattern.
+func (ck *CompositeKeystore) Match(issuer string) (key interface{}, tenant string) {
+	if ks := ck.route(issuer); ks != nil {
+		return lookupKey(ks, issuer)
+	}
+
+	for _, ks := range ck.members() {
+		if key, tenant := lookupKey(ks, issuer); key != nil {
+			return key, tenant
+		}
+	}
+
+	return nil, ""
+}
+
+// members returns Keystores in priority order, followed by Writable if it
+// is not among them.
+func (ck *CompositeKeystore) members() []Keystore {
+	if ck.Writable == nil {
+		return ck.Keystores
+	}
+	if containsKeystore(ck.Keystores, ck.Writable) {
+		return ck.Keystores
+	}
+	return append(ck.Keystores[:len(ck.Keystores):len(ck.Keystores)], ck.Writable)
+}
+
+// containsKeystore determines whether a list contains a keystore. Only
+// pointers are compared, since comparing other values may panic (e.g. if
+// their type is a func, or a struct that holds a slice).
+func containsKeystore(list []Keystore, ks Keystore) bool {
+	if ks == nil || reflect.ValueOf(ks).Kind() != reflect.Ptr {
+		return false
+	}
+	for _, member := range list {
+		if member == ks {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the keystore whose prefix is the longest match for issuer,
+// or nil if no prefix matches.
+func (ck *CompositeKeystore) route(issuer string) Keystore {
+	var match string
+	var ks Keystore
+	for prefix, rks := range ck.Routes {
+		if strings.HasPrefix(issuer, prefix) && (ks == nil || len(prefix) > len(match)) {
+			match, ks = prefix, rks
+		}
+	}
+	return ks
+}
@@ -0,0 +1,121 @@
+package jwtauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+// funcKeystore is a Keystore whose values cannot be compared.
+type funcKeystore func(issuer string) interface{}
+
+func (fk funcKeystore) Trust(issuer string, key interface{}) error { return nil }
+func (fk funcKeystore) RevokeTrust(issuer string)                  {}
+func (fk funcKeystore) Get(issuer string) interface{}              { return fk(issuer) }
+
+var _ = Describe("CompositeKeystore", func() {
+	var simple *jwtauth.SimpleKeystore
+	var named, partners *jwtauth.NamedKeystore
+	var store *jwtauth.CompositeKeystore
+
+	BeforeEach(func() {
+		simple = &jwtauth.SimpleKeystore{Key: hmacKey1}
+		named = &jwtauth.NamedKeystore{}
+		partners = &jwtauth.NamedKeystore{}
+		Ω(named.Trust("alice", hmacKey2)).Should(Succeed())
+		Ω(partners.Trust("partner:acme", &rsaKey1.PublicKey)).Should(Succeed())
+
+		store = &jwtauth.CompositeKeystore{
+			Keystores: []jwtauth.Keystore{named, simple},
+			Writable:  named,
+			Routes:    map[string]jwtauth.Keystore{"partner:": partners},
+		}
+	})
+
+	Context("Subscribe()", func() {
+		It("forwards events from member keystores", func() {
+			var issuers []string
+			cancel := store.Subscribe(func(ev jwtauth.KeystoreEvent) { issuers = append(issuers, ev.Issuer) })
+
+			Ω(store.Trust("bob", hmacKey1)).Should(Succeed())
+			Ω(partners.Trust("partner:initech", hmacKey1)).Should(Succeed())
+			Ω(issuers).Should(Equal([]string{"bob", "partner:initech"}))
+
+			cancel()
+			store.RevokeTrust("bob")
+			Ω(issuers).Should(HaveLen(2))
+		})
+	})
+
+	Context("Get()", func() {
+		It("consults keystores in order", func() {
+			Ω(store.Get("alice")).Should(Equal(hmacKey2))
+			Ω(store.Get("bob")).Should(Equal(hmacKey1))
+		})
+
+		It("routes issuers by prefix", func() {
+			Ω(store.Get("partner:acme")).Should(Equal(&rsaKey1.PublicKey))
+			Ω(store.Get("partner:evil")).Should(BeNil())
+		})
+
+		It("prefers the longest prefix", func() {
+			vip := &jwtauth.NamedKeystore{}
+			Ω(vip.Trust("partner:acme", ecKey1)).Should(Succeed())
+			store.Routes["partner:ac"] = vip
+
+			Ω(store.Get("partner:acme")).Should(Equal(&ecKey1.PublicKey))
+		})
+
+		It("passes tenants through", func() {
+			Ω(partners.TrustPattern("partner:*", hmacKey1)).Should(Succeed())
+
+			key, tenant := store.Match("partner:globex")
+			Ω(key).Should(Equal(hmacKey1))
+			Ω(tenant).Should(Equal("globex"))
+		})
+
+		It("returns nil when no keystore has a key", func() {
+			empty := &jwtauth.CompositeKeystore{Keystores: []jwtauth.Keystore{named}}
+			Ω(empty.Get("bob")).Should(BeNil())
+		})
+	})
+
+	Context("Trust() and RevokeTrust()", func() {
+		It("delegate to the writable keystore", func() {
+			Ω(store.Trust("carol", ecKey1)).Should(Succeed())
+			Ω(named.Get("carol")).Should(Equal(&ecKey1.PublicKey))
+
+			store.RevokeTrust("alice")
+			Ω(named.Get("alice")).Should(BeNil())
+			Ω(store.Get("alice")).Should(Equal(hmacKey1))
+		})
+
+		It("honour keys trusted in a writable keystore that is not a member", func() {
+			extra := &jwtauth.NamedKeystore{}
+			store.Keystores = []jwtauth.Keystore{named}
+			store.Writable = extra
+			Ω(store.Trust("carol", ecKey1)).Should(Succeed())
+			Ω(store.Get("carol")).Should(Equal(&ecKey1.PublicKey))
+			Ω(store.Get("alice")).Should(Equal(hmacKey2))
+			Ω(store.Keystores).Should(HaveLen(1))
+		})
+
+		It("accept keystores that cannot be compared", func() {
+			fk := funcKeystore(func(issuer string) interface{} { return hmacKey1 })
+			store.Keystores = []jwtauth.Keystore{fk}
+			store.Writable = funcKeystore(func(issuer string) interface{} { return nil })
+			Expect(func() {
+				Ω(store.Get("carol")).Should(Equal(hmacKey1))
+				store.Subscribe(func(jwtauth.KeystoreEvent) {})()
+			}).NotTo(Panic())
+		})
+
+		It("fail without a writable keystore", func() {
+			store.Writable = nil
+			Ω(store.Trust("carol", ecKey1)).ShouldNot(Succeed())
+			Expect(func() {
+				store.RevokeTrust("alice")
+			}).NotTo(Panic())
+		})
+	})
+})
@@ -9,6 +9,8 @@ type contextKey int
 const (
 	claimsKey contextKey = iota + 1
 	tokenKey
+	tenantKey
+	dpopKeyKey
 )
 
 // WithClaims creates a child context containing the given JWT claims.
@@ -36,3 +38,30 @@ func ContextToken(ctx context.Context) string {
 	}
 	return ""
 }
+
+// WithTenant creates a child context containing the given tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// ContextTenant retrieves the tenant associated with the request: the part of
+// the JWT's issuer that matched a wildcard when the keystore looked up the
+// issuer's key using a pattern. See NamedKeystore.TrustPattern.
+func ContextTenant(ctx context.Context) string {
+	if tenant, _ := ctx.Value(tenantKey).(string); tenant != "" {
+		return tenant
+	}
+	return ""
+}
+
+// WithDPoPKey creates a child context containing the given DPoP key.
+func WithDPoPKey(ctx context.Context, key interface{}) context.Context {
+	return context.WithValue(ctx, dpopKeyKey, key)
+}
+
+// ContextDPoPKey retrieves the public key to which the request's token is
+// bound, if the token was presented with a valid DPoP proof. See the DPoP
+// option.
+func ContextDPoPKey(ctx context.Context) interface{} {
+	return ctx.Value(dpopKeyKey)
+}
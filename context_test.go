@@ -60,4 +60,42 @@ var _ = Describe("Context accessor functions", func() {
 			})
 		})
 	})
+	Describe("ContextTenant", func() {
+		Context("given a context with a tenant", func() {
+			BeforeEach(func() {
+				ctx = WithTenant(context.Background(), "acme")
+			})
+			It("returns the tenant", func() {
+				Ω(ContextTenant(ctx)).Should(Equal("acme"))
+			})
+		})
+
+		Context("given a context that has no tenant", func() {
+			BeforeEach(func() {
+				ctx = context.Background()
+			})
+			It("returns an empty string", func() {
+				Ω(ContextTenant(ctx)).Should(Equal(""))
+			})
+		})
+	})
+	Describe("ContextDPoPKey", func() {
+		Context("given a context with a DPoP key", func() {
+			BeforeEach(func() {
+				ctx = WithDPoPKey(context.Background(), []byte("key"))
+			})
+			It("returns the key", func() {
+				Ω(ContextDPoPKey(ctx)).Should(Equal([]byte("key")))
+			})
+		})
+
+		Context("given a context that has no DPoP key", func() {
+			BeforeEach(func() {
+				ctx = context.Background()
+			})
+			It("returns nil", func() {
+				Ω(ContextDPoPKey(ctx)).Should(BeNil())
+			})
+		})
+	})
 })
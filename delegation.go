@@ -0,0 +1,140 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// Delegation mints downstream tokens on behalf of the caller of an
+	// inbound request, so that the recipient sees both the original subject
+	// and the service that is acting for it. The downstream token carries:
+	//     - the inbound "sub"
+	//     - an "act" claim (RFC 8693 Section 4.1) whose "sub" is Actor, and
+	//       which nests any "act" claim of the inbound token
+	//     - Audience as its "aud"
+	//     - the inbound scopes, narrowed to Scopes
+	//
+	// Delegation is a TokenSource; to call another service on behalf of the
+	// caller, use it with a Transport and pass the inbound request's context
+	// to the outbound request:
+	//
+	//     delegation := &jwtauth.Delegation{Key: myKey, Actor: "svc-a", Audience: []string{"svc-b"}}
+	//     client := &http.Client{Transport: &jwtauth.Transport{Source: delegation}}
+	Delegation struct {
+		// Key signs downstream tokens.
+		Key interface{}
+
+		// Actor identifies the service that is acting on behalf of the
+		// subject. It is also the issuer of downstream tokens, unless Issuer
+		// is set. If empty, downstream tokens impersonate the subject: they
+		// carry the inbound "act" claim (if any) unchanged.
+		Actor string
+
+		// Issuer is the issuer of downstream tokens. If empty, Actor is used;
+		// at least one of them must be set.
+		Issuer string
+
+		// Audience is the audience of downstream tokens.
+		Audience []string
+
+		// Scopes, if not nil, limits the scopes of downstream tokens. Scopes
+		// that the inbound token does not hold are never granted.
+		Scopes []string
+
+		// TTL is the lifetime of downstream tokens. They never outlive the
+		// inbound token. If zero, DefaultDelegationTTL is used.
+		TTL time.Duration
+	}
+)
+
+// DefaultDelegationTTL is the lifetime of delegated tokens, unless told
+// otherwise.
+const DefaultDelegationTTL = 5 * time.Minute
+
+// Token implements jwtauth.TokenSource#Token. It mints a downstream token for
+// the claims found by ContextClaims, or supplies no token if the context has
+// none.
+func (d *Delegation) Token(ctx context.Context) (string, error) {
+	if ContextToken(ctx) == "" {
+		return "", nil
+	}
+	claims, err := d.Claims(ContextClaims(ctx), time.Now())
+	if err != nil {
+		return "", err
+	}
+	return NewToken(d.Key, claims)
+}
+
+// Claims returns the claims of a downstream token that is delegated from the
+// inbound claims at the specified time.
+func (d *Delegation) Claims(inbound Claims, now time.Time) (Claims, error) {
+	if inbound == nil || inbound.Subject() == "" {
+		return nil, errors.New("cannot delegate a token with no subject")
+	}
+
+	act := inbound["act"]
+	if d.Actor != "" {
+		actor := Claims{"sub": d.Actor}
+		if act != nil {
+			actor["act"] = act
+		}
+		act = actor
+	}
+
+	issuer := d.Issuer
+	if issuer == "" {
+		issuer = d.Actor
+	}
+	if issuer == "" {
+		return nil, errors.New("cannot delegate without an Issuer or Actor")
+	}
+
+	ttl := d.TTL
+	if ttl == 0 {
+		ttl = DefaultDelegationTTL
+	}
+	exp := now.Add(ttl)
+	if _, ok := inbound["exp"]; ok && inbound.ExpiresAt().Before(exp) {
+		exp = inbound.ExpiresAt()
+	}
+	if !exp.After(now) {
+		return nil, errors.New("cannot delegate an expired token")
+	}
+
+	claims := Claims{
+		"iss": issuer,
+		"sub": inbound.Subject(),
+		"iat": now.Unix(),
+		"exp": exp.Unix(),
+	}
+	if act != nil {
+		claims["act"] = act
+	}
+	if len(d.Audience) > 0 {
+		claims["aud"] = d.Audience
+	}
+	if scopes := narrowScopes(inbound.Strings(ScopesClaim), d.Scopes); len(scopes) > 0 {
+		claims[ScopesClaim] = scopes
+	}
+	return claims, nil
+}
+
+// narrowScopes returns the held scopes that are also wanted, in the order in
+// which they are held. If wanted is nil, it returns all of the held scopes.
+func narrowScopes(held, wanted []string) []string {
+	if wanted == nil {
+		return held
+	}
+	var scopes []string
+	for _, h := range held {
+		for _, w := range wanted {
+			if h == w {
+				scopes = append(scopes, h)
+				break
+			}
+		}
+	}
+	return scopes
+}
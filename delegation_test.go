@@ -0,0 +1,122 @@
+package jwtauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("Delegation", func() {
+	var delegation *jwtauth.Delegation
+	var inbound jwtauth.Claims
+	now := time.Now()
+
+	BeforeEach(func() {
+		delegation = &jwtauth.Delegation{
+			Key:      hmacKey2,
+			Actor:    "svc-a",
+			Audience: []string{"svc-b"},
+		}
+		inbound = jwtauth.Claims{
+			"iss":               "login",
+			"sub":               "alice",
+			"aud":               "svc-a",
+			"exp":               now.Add(time.Hour).Unix(),
+			jwtauth.ScopesClaim: []interface{}{"read", "write", "admin"},
+		}
+	})
+
+	It("acts on behalf of the subject", func() {
+		claims, err := delegation.Claims(inbound, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Issuer()).Should(Equal("svc-a"))
+		Ω(claims.Subject()).Should(Equal("alice"))
+		Ω(claims.Strings("aud")).Should(Equal([]string{"svc-b"}))
+		Ω(claims.Actors()).Should(Equal([]string{"svc-a"}))
+		Ω(claims.Strings(jwtauth.ScopesClaim)).Should(Equal([]string{"read", "write", "admin"}))
+		Ω(claims.ExpiresAt().Unix()).Should(Equal(now.Add(jwtauth.DefaultDelegationTTL).Unix()))
+	})
+
+	It("extends the delegation chain", func() {
+		inbound["act"] = map[string]interface{}{"sub": "svc-z"}
+		claims, err := delegation.Claims(inbound, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Actors()).Should(Equal([]string{"svc-a", "svc-z"}))
+	})
+
+	It("narrows scopes", func() {
+		delegation.Scopes = []string{"read", "delete"}
+		claims, err := delegation.Claims(inbound, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Strings(jwtauth.ScopesClaim)).Should(Equal([]string{"read"}))
+
+		delegation.Scopes = []string{}
+		claims, err = delegation.Claims(inbound, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims).ShouldNot(HaveKey(jwtauth.ScopesClaim))
+	})
+
+	It("never outlives the inbound token", func() {
+		inbound["exp"] = now.Add(time.Minute).Unix()
+		claims, err := delegation.Claims(inbound, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.ExpiresAt().Unix()).Should(Equal(now.Add(time.Minute).Unix()))
+
+		inbound["exp"] = now.Add(-time.Minute).Unix()
+		_, err = delegation.Claims(inbound, now)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("requires a subject", func() {
+		delete(inbound, "sub")
+		_, err := delegation.Claims(inbound, now)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("requires an issuer", func() {
+		delegation.Actor = ""
+		_, err := delegation.Claims(inbound, now)
+		Ω(err).Should(HaveOccurred())
+
+		delegation.Issuer = "svc-a"
+		claims, err := delegation.Claims(inbound, now)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Issuer()).Should(Equal("svc-a"))
+	})
+
+	It("mints tokens for the inbound request", func() {
+		tok, err := delegation.Token(context.Background())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tok).Should(BeEmpty())
+
+		inboundToken, err := jwtauth.NewToken(hmacKey1, inbound)
+		Ω(err).ShouldNot(HaveOccurred())
+		req, _ := http.NewRequest("GET", "http://svc-a/", nil)
+		setBearerHeader(req, inboundToken)
+
+		var downstream jwtauth.Claims
+		svcB := jwtauth.Authenticate(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey2})(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				downstream = jwtauth.ContextClaims(ctx)
+				return nil
+			})
+		svcA := jwtauth.Authenticate(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1})(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				tok, err := delegation.Token(ctx)
+				if err != nil {
+					return err
+				}
+				req, _ := http.NewRequest("GET", "http://svc-b/", nil)
+				setBearerHeader(req, tok)
+				return svcB(ctx, w, req)
+			})
+		Ω(svcA(context.Background(), httptest.NewRecorder(), req)).Should(Succeed())
+		Ω(downstream.Subject()).Should(Equal("alice"))
+		Ω(downstream.Actors()).Should(Equal([]string{"svc-a"}))
+	})
+})
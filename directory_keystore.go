@@ -0,0 +1,268 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// DirectoryKeystore is a concurrency-safe Keystore that trusts the keys
+	// found in a directory, one file per issuer. The file "<Dir>/<issuer>.pem"
+	// or "<Dir>/<issuer>.jwk" contains the keys for <issuer>: a .pem file
+	// must contain at least one PEM block, and a .jwk file must contain a JWK
+	// or a JWK Set. Every key in the file is trusted. Unlike LoadKey,
+	// DirectoryKeystore never treats a file's raw content as an HMAC key, so
+	// an empty or garbled file cannot cause tokens to be trusted. Hidden files
+	// and files with other extensions are ignored, which makes
+	// DirectoryKeystore suitable for use with Kubernetes secrets that are
+	// mounted as a volume.
+	//
+	// Call Reload to rescan the directory, or Watch to rescan it periodically.
+	// Every scan replaces the trusted keys in a single atomic step: issuers
+	// whose file has appeared or changed become trusted, and issuers whose file
+	// has disappeared are no longer trusted. If a file cannot be loaded, the
+	// problem is logged and the issuer's previous key (if any) remains trusted.
+	//
+	// Subscribers (see Subscribe) are notified of every issuer that a scan
+	// adds, removes or rotates, and of every file that cannot be loaded.
+	//
+	// Trust() and RevokeTrust() have no effect, although Trust() returns an
+	// error; the directory is the single source of truth.
+	DirectoryKeystore struct {
+		// Dir is the directory that contains key files.
+		Dir string
+
+		// ErrorLog receives messages about files that could not be loaded. If
+		// nil, messages are logged using the log package's standard logger.
+		ErrorLog *log.Logger
+
+		reload sync.Mutex
+		mu     sync.RWMutex
+		keys   map[string]interface{}
+		hashes map[string][sha256.Size]byte
+		events observers
+		poller
+	}
+)
+
+// directoryKeyExtensions lists the file extensions that DirectoryKeystore
+// loads, in order of preference.
+var directoryKeyExtensions = []string{".pem", ".jwk"}
+
+// NewDirectoryKeystore creates a DirectoryKeystore, loads all of the keys in
+// dir, and (if interval is positive) starts watching dir for changes. It
+// returns an error if dir cannot be read.
+func NewDirectoryKeystore(dir string, interval time.Duration) (*DirectoryKeystore, error) {
+	dk := &DirectoryKeystore{Dir: dir}
+	if err := dk.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		dk.Watch(interval)
+	}
+	return dk, nil
+}
+
+// Trust implements jwtauth.Keystore#Trust
+func (dk *DirectoryKeystore) Trust(issuer string, key interface{}) error {
+	return fmt.Errorf("cannot trust additional keys; add a file to %s instead", dk.Dir)
+}
+
+// RevokeTrust implements jwtauth.Keystore#RevokeTrust
+func (dk *DirectoryKeystore) RevokeTrust(issuer string) {
+}
+
+// Subscribe registers a function to be notified of changes to the trusted
+// keys and of failures to load them, and returns a function that cancels the
+// subscription. Events are delivered in the goroutine that called Reload.
+func (dk *DirectoryKeystore) Subscribe(fn EventFunc) (unsubscribe func()) {
+	return dk.events.subscribe(fn)
+}
+
+// Get implements jwtauth.Keystore#Get
+func (dk *DirectoryKeystore) Get(issuer string) interface{} {
+	dk.mu.RLock()
+	defer dk.mu.RUnlock()
+
+	if dk.keys != nil {
+		return dk.keys[issuer]
+	}
+
+	return nil
+}
+
+// Reload rescans the directory and updates the trusted keys to match. It
+// returns an error only if the directory itself cannot be read, in which case
+// the trusted keys are left unchanged.
+func (dk *DirectoryKeystore) Reload() error {
+	dk.reload.Lock()
+	defer dk.reload.Unlock()
+
+	files, err := ioutil.ReadDir(dk.Dir)
+	if err != nil {
+		dk.events.emit(KeystoreEvent{Type: EventRefreshFailed, Err: err})
+		return err
+	}
+
+	// Find the files to load, in order of preference: if an issuer has both
+	// a .pem and a .jwk file, we load the .pem.
+	paths := map[string]string{}
+	sort.Slice(files, func(i, j int) bool {
+		return extensionRank(files[i].Name()) < extensionRank(files[j].Name())
+	})
+	for _, fi := range files {
+		name := fi.Name()
+		ext := filepath.Ext(name)
+		issuer := strings.TrimSuffix(name, ext)
+		if fi.IsDir() || strings.HasPrefix(name, ".") || extensionRank(name) < 0 || issuer == "" {
+			continue
+		}
+		if other, ok := paths[issuer]; ok {
+			logf(dk.ErrorLog, "jwtauth: ignoring %s; issuer '%s' already loaded from %s", name, issuer, filepath.Base(other))
+			continue
+		}
+		paths[issuer] = filepath.Join(dk.Dir, name)
+	}
+
+	// Read the files without holding the lock; a slow filesystem must not
+	// block token verification.
+	dk.mu.RLock()
+	oldKeys, oldHashes := dk.keys, dk.hashes
+	dk.mu.RUnlock()
+
+	var failures []KeystoreEvent
+	keys := make(map[string]interface{}, len(paths))
+	hashes := make(map[string][sha256.Size]byte, len(paths))
+	for issuer, path := range paths {
+		key, hash, err := loadKeyFile(path, oldHashes[issuer], oldKeys[issuer])
+		if err != nil {
+			logf(dk.ErrorLog, "jwtauth: cannot load key for issuer '%s' from %s: %s", issuer, path, err)
+			failures = append(failures, KeystoreEvent{Type: EventRefreshFailed, Issuer: issuer, Err: err})
+			if old, ok := oldKeys[issuer]; ok {
+				keys[issuer], hashes[issuer] = old, oldHashes[issuer]
+			}
+			continue
+		}
+		keys[issuer], hashes[issuer] = key, hash
+	}
+
+	dk.mu.Lock()
+	dk.keys, dk.hashes = keys, hashes
+	dk.mu.Unlock()
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Issuer < failures[j].Issuer
+	})
+	for _, ev := range append(failures, diffKeys(oldKeys, keys)...) {
+		dk.events.emit(ev)
+	}
+
+	return nil
+}
+
+// Watch starts rescanning the directory every interval, until Close is
+// called. Errors are logged. Calling Watch again replaces the previous
+// schedule.
+func (dk *DirectoryKeystore) Watch(interval time.Duration) {
+	dk.poller.watch(interval, func() {
+		if err := dk.Reload(); err != nil {
+			logf(dk.ErrorLog, "jwtauth: cannot reload keys from %s: %s", dk.Dir, err)
+		}
+	})
+}
+
+// Close stops watching the directory. The keys that were trusted remain
+// trusted.
+func (dk *DirectoryKeystore) Close() {
+	dk.poller.close()
+}
+
+// extensionRank returns the position of name's extension in the list of
+// extensions that DirectoryKeystore loads, or -1 if it is not in the list.
+func extensionRank(name string) int {
+	ext := filepath.Ext(name)
+	for i, e := range directoryKeyExtensions {
+		if ext == e {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadKeyFile loads a trustable key (or key set) from a file. If the file's content has
+// the same hash as before, it returns the old key without parsing the file.
+// The file's extension determines its format; raw content is never used as
+// an HMAC key.
+func loadKeyFile(path string, oldHash [sha256.Size]byte, oldKey interface{}) (interface{}, [sha256.Size]byte, error) {
+	material, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, oldHash, err
+	}
+
+	hash := sha256.Sum256(material)
+	if oldKey != nil && hash == oldHash {
+		return oldKey, hash, nil
+	}
+
+	// Trust every key in the file; a JWK Set keeps its key IDs.
+	var key interface{}
+	switch filepath.Ext(path) {
+	case ".pem":
+		key, err = parseKeys(material, nil)
+	case ".jwk":
+		var probe struct {
+			Keys json.RawMessage `json:"keys"`
+		}
+		if err = json.Unmarshal(material, &probe); err == nil {
+			if probe.Keys != nil {
+				key, err = ParseJWKSet(material)
+			} else {
+				key, err = parseJWKs(material)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported file extension")
+	}
+	if err != nil {
+		return nil, hash, err
+	}
+	if keys, ok := key.([]interface{}); ok && len(keys) == 1 {
+		key = keys[0]
+	}
+	if hasEmptyKey(key) {
+		return nil, hash, fmt.Errorf("key is empty")
+	}
+
+	key, err = trustableKey(key)
+	return key, hash, err
+}
+
+// hasEmptyKey determines whether a key, or any key in a set, is an empty
+// HMAC key.
+func hasEmptyKey(key interface{}) bool {
+	switch kt := key.(type) {
+	case []byte:
+		return len(kt) == 0
+	case []interface{}:
+		for _, k := range kt {
+			if hasEmptyKey(k) {
+				return true
+			}
+		}
+	case *JWKSet:
+		for _, jwk := range kt.Keys {
+			if hasEmptyKey(jwk.Key) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,8 +1,8 @@
 /*
 Package jwtauth provides a middleware for the Goa framework that parses and
 validates JSON Web Tokens (JWTs) that appear in requests, then adds them
-to the request context. It supports any JWT algorithm that uses RSA, ECDSA
-or HMAC.
+to the request context. It supports any JWT algorithm that uses RSA, ECDSA,
+Ed25519 (EdDSA) or HMAC.
 
 When you setup your goa.Service, install the jwtauth middlewares:
 
@@ -68,6 +68,55 @@ Using a NamedKeystore, you can grant or revoke trust at any time, even while
 the application is running, and your changes will take effect on the next
 request.
 
+A NamedKeystore can also trust a pattern of issuers, such as every tenant of
+a multi-tenant identity provider. The issuer's tenant becomes available to
+your controllers and authorization function via ContextTenant():
+
+		store.TrustPattern("https://login.example.com/tenants/*", keys)
+
+During key rotation, TrustUntil keeps trusting an old key for a while,
+alongside the new key; when the time comes, the old key drops out of the
+keystore by itself:
+
+		store.TrustUntil("us.acme.com", oldKey, time.Now().Add(48*time.Hour))
+		store.Trust("us.acme.com", newKey)
+
+If your keys are distributed as files (e.g. a Kubernetes secret mounted as
+a volume), a DirectoryKeystore trusts one issuer per file and reloads the
+directory periodically:
+
+		store, err := jwtauth.NewDirectoryKeystore("/etc/jwt-issuers", time.Minute)
+
+To trust an OpenID Connect provider, give its issuer URL to NewOIDCKeystore.
+The keystore discovers the provider's key set and signing algorithms, and
+repeats discovery periodically to pick up new keys:
+
+		store, err := jwtauth.NewOIDCKeystore("https://login.example.com", time.Hour)
+
+To keep an audit trail, subscribe to a keystore's events. NamedKeystore,
+DirectoryKeystore, RemoteKeystore and CompositeKeystore report issuers that
+become trusted, are revoked, expire or have their key rotated, identifying
+keys by fingerprint:
+
+		store.Subscribe(func(ev jwtauth.KeystoreEvent) {
+			log.Printf("%s %s %s", ev.Type, ev.Issuer, ev.Fingerprint)
+		})
+
+
+Loading Keys
+
+LoadKey transforms raw key material into a properly-typed key that can be
+trusted by a keystore. It understands PEM-encoded keys and certificates,
+JWK and JWK Set documents, and treats anything else as an HMAC secret:
+
+		store.Trust("us.acme.com", jwtauth.LoadKey(ioutil.ReadFile("us.pem")))
+		store.Trust("eu.acme.com", jwtauth.LoadKey(ioutil.ReadFile("eu.jwk")))
+
+LoadKey panics on malformed input, which is convenient at startup. To load
+keys at runtime, call ParseKey instead; it returns an error that names the
+offending PEM block or JWK. ParseKeyWithPassphrase can also decrypt
+password-protected private keys.
+
 
 Custom Authorization
 
@@ -109,8 +158,8 @@ by providing the Extraction() option:
     }
 
     store := jwt.SimpleKeystore{[]byte("This is my HMAC key")}
-    middleware := jwtauth.New(scheme, store,
-      jwtauth.Extraction(myExtraction)
+    middleware := jwtauth.AuthenticateWithOptions(scheme, store,
+      jwtauth.Extraction(myExtraction),
     )
 
 The default extraction behavior, described below, should be sufficient for
@@ -131,6 +180,102 @@ header, any of the following would be acceptable:
 		Authorization: AnyOtherWordHere <base64_token>
 
 
+Caching
+
+Verifying an RSA signature is expensive. If clients present the same token
+many times, the Cache() option lets the middleware remember tokens that it
+has already verified until they expire:
+
+		middleware := jwtauth.AuthenticateWithOptions(scheme, store,
+			jwtauth.Cache(jwtauth.NewTokenCache(10000)),
+		)
+
+The cache is emptied whenever the keystore revokes or rotates a key.
+
+
+Opaque Tokens
+
+Some authorization servers issue opaque reference tokens rather than JWTs.
+To accept them, provide the Introspection() option; the middleware asks the
+server's RFC 7662 introspection endpoint about every token that is not a
+JWT, and caches the answer until the token expires:
+
+		introspector := &jwtauth.Introspector{
+			Endpoint:     "https://login.example.com/oauth2/introspect",
+			ClientID:     "my-service",
+			ClientSecret: os.Getenv("CLIENT_SECRET"),
+		}
+		middleware := jwtauth.AuthenticateWithOptions(scheme, store,
+			jwtauth.Introspection(introspector),
+		)
+
+The introspection response becomes the request's claims, and its "scope"
+member becomes the list of scopes that DefaultAuthorization checks.
+
+
+Encrypted Tokens
+
+Tokens that carry sensitive claims can be encrypted for the service that
+receives them. To accept encrypted tokens (JWE), provide the Decryption()
+option with the service's private keys:
+
+		keys := &jwtauth.DecryptionKeystore{}
+		keys.Add("2024-01", myPrivateKey)
+		middleware := jwtauth.AuthenticateWithOptions(scheme, store,
+			jwtauth.Decryption(keys),
+		)
+
+The middleware supports the RSA-OAEP, ECDH-ES and A256KW key management
+algorithms and the A256GCM and A128CBC-HS256 content encryption algorithms.
+An encrypted token must contain a signed JWT, whose signature is verified
+against the keystore as usual; encryption alone does not prove who issued
+a token.
+
+To issue encrypted tokens, call NewEncryptedToken with the issuer's signing
+key and the recipient's public key:
+
+		tok, err := jwtauth.NewEncryptedToken(alicesKey, bobsPublicKey, claims)
+
+
+Proof of Possession
+
+A stolen bearer token can be used by anyone. To bind tokens to the client
+that they were issued to, provide the DPoP() option; tokens whose "cnf"
+claim names a key thumbprint must then be accompanied by a DPoP proof (RFC
+9449) that was signed with that key:
+
+		middleware := jwtauth.AuthenticateWithOptions(scheme, store,
+			jwtauth.DPoP(&jwtauth.DPoPVerifier{}),
+		)
+
+ContextDPoPKey() returns the client's public key.
+
+Services that use mutual TLS can bind tokens to client certificates instead
+(RFC 8705). The CertificateBinding() option rejects a token whose "cnf"
+claim names a certificate thumbprint unless the request was made with that
+certificate.
+
+
+Revocation
+
+A JWT remains valid until it expires, even if the user's session has been
+killed in the meantime. To reject such tokens, provide the Revocation()
+option with a RevocationStore, which can revoke tokens by "jti", by "sid",
+or by "iss" and "sub" (every token that the issuer issued to the subject
+before a certain time):
+
+		revoked := &jwtauth.MemoryRevocationStore{}
+		middleware := jwtauth.AuthenticateWithOptions(scheme, store,
+			jwtauth.Revocation(revoked),
+		)
+
+		// later, when alice logs out everywhere:
+		revoked.RevokeSubject("https://login.example.com", "alice", time.Now())
+
+FileRevocationStore reads revocations from a file that can be distributed
+to every instance of your service.
+
+
 Token Management
 
 If you need to create tokens, jwtauth contains a simplistic helper that helps
@@ -140,6 +285,70 @@ to shadow the dependency on dgrijalva/jwt:
 		token, err := NewToken("my HMAC key", claims)
 		fmt.Println("the magic token is", token)
 
+NewToken chooses a signing algorithm based on the type of key (and, for
+ECDSA keys, the curve). To use a specific algorithm such as RSA-PSS, call
+NewTokenWithAlgorithm instead:
+
+		token, err := NewTokenWithAlgorithm(rsaKey, "PS256", claims)
+
+To issue short-lived access tokens along with long-lived refresh tokens, use
+a RefreshIssuer. Each refresh token can be exchanged for a new pair exactly
+once; if a client presents a refresh token a second time, the whole family
+of tokens that descends from the same sign-in is revoked:
+
+		issuer := &jwtauth.RefreshIssuer{Issuer: "me", Key: myKey, Store: &jwtauth.MemoryRefreshStore{}}
+		pair, err := issuer.Issue(jwtauth.NewClaims("sub", "alice"))
+		...
+		pair, err = issuer.Refresh(pair.RefreshToken)
+
+Refresh tokens are never accepted by the authentication middleware.
+
+To let recipients discover your public keys, sign tokens with a
+SigningKeySet and publish it as a JWK Set:
+
+		keys, err := jwtauth.NewSigningKeySet("2024-01", myKey)
+		http.Handle(jwtauth.JWKSPath, keys)
+		token, err := keys.NewToken(claims)
+
+To rotate keys, Announce the next key, wait for recipients to fetch it, and
+then Rotate; the retired key stays published for a grace period.
+
+
+Calling Other Services
+
+To authenticate outbound requests, use a Transport with a TokenSource. The
+source may supply a fixed token, mint tokens of its own, or relay the token
+of the inbound request that is being served:
+
+		source := jwtauth.NewIssuerTokenSource(myKey, jwtauth.NewClaims("iss", "me"), time.Hour)
+		client := &http.Client{Transport: &jwtauth.Transport{Source: source}}
+
+Minted tokens are reused until shortly before they expire. To reuse the
+tokens of any other source, wrap it with NewReuseTokenSource. A reused token
+is shared by every caller, so never wrap a source whose tokens depend on the
+inbound request; NewReuseTokenSource leaves forwarding sources and
+Delegations unwrapped for this reason.
+
+To call another service on behalf of the caller of an inbound request, use
+a Delegation as the source. It mints tokens for the inbound subject with an
+"act" claim that identifies your service, and the recipient can inspect the
+chain of services that acted for the subject by calling Claims.Actors():
+
+		delegation := &jwtauth.Delegation{Key: myKey, Actor: "me", Audience: []string{"them"}}
+		client := &http.Client{Transport: &jwtauth.Transport{Source: delegation}}
+
+To centralize delegation in a security token service, mount a TokenExchange
+handler. It implements the token exchange grant of RFC 8693, verifying
+subject and actor tokens against a keystore and issuing tokens that are
+limited to an allowed set of audiences and to the subject's own scopes:
+
+		http.Handle("/token", &jwtauth.TokenExchange{
+			Keystore:  store,
+			Issuer:    "https://sts.example.com",
+			Key:       stsKey,
+			Audiences: []string{"billing", "inventory"},
+		})
+
 
 Error Handling
 
@@ -150,6 +359,9 @@ ErrUnsupported (500): the token or configuration uses an unsupported feature.
 
 ErrInvalidToken (401): the token is malformed or its signature is bad.
 
+ErrUnavailable (503): a server that verifies tokens, such as an introspection
+endpoint, failed to answer.
+
 ErrAuthenticationFailed (403): the token is well-formed but the issuer is not
 trusted, it has expired, or is not yet valid.
 
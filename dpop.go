@@ -0,0 +1,218 @@
+package jwtauth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type (
+	// DPoPVerifier checks that access tokens are presented by the client that
+	// they were issued to, using Demonstrating Proof of Possession (DPoP) as
+	// specified by RFC 9449. A DPoP-bound token carries the thumbprint of the
+	// client's public key in its "cnf" claim ("jkt" member). The client sends
+	// it with the DPoP authorization scheme, plus a DPoP header that contains
+	// a proof: a JWT, signed with the client's private key, that names the
+	// request's method and URL.
+	//
+	// A DPoP-bound token is rejected unless it is accompanied by a valid
+	// proof; other tokens are accepted as bearer tokens unless Required is
+	// set. To use a DPoPVerifier, pass it to AuthenticateWithOptions using
+	// the DPoP option. DPoPVerifier is safe for concurrent use.
+	DPoPVerifier struct {
+		// Required rejects tokens that are not DPoP-bound.
+		Required bool
+
+		// MaxAge is how far a proof's "iat" may be from the current time, in
+		// either direction. If zero, DefaultDPoPMaxAge is used.
+		MaxAge time.Duration
+
+		// RequestURL returns the URL of a request as the client sees it, for
+		// comparison with a proof's "htu" claim. If nil, the URL is built from
+		// the request's Host header and whether it was received over TLS;
+		// set RequestURL if your service runs behind a reverse proxy.
+		RequestURL func(*http.Request) string
+
+		mu    sync.Mutex
+		seen  map[string]bool
+		order *list.List // of *usedProof, in the order they were seen
+	}
+
+	// usedProof is an entry in DPoPVerifier's replay cache.
+	usedProof struct {
+		jti   string
+		until time.Time
+	}
+)
+
+// DefaultDPoPMaxAge is the default value of DPoPVerifier.MaxAge.
+var DefaultDPoPMaxAge = time.Minute
+
+// verify checks the DPoP proof (if any) that accompanies a token, and
+// returns the public key to which the token is bound, or nil if it is a
+// bearer token.
+func (v *DPoPVerifier) verify(scheme string, req *http.Request, rawToken string, claims Claims) (interface{}, error) {
+	var jkt string
+	if cnf, ok := claims["cnf"].(map[string]interface{}); ok {
+		jkt, _ = cnf["jkt"].(string)
+	}
+
+	isDPoP := strings.EqualFold(scheme, "DPoP")
+	switch {
+	case !isDPoP && jkt != "":
+		return nil, ErrInvalidToken("DPoP-bound token presented as a bearer token")
+	case !isDPoP && v.Required:
+		return nil, ErrInvalidToken("DPoP-bound token required")
+	case !isDPoP:
+		return nil, nil
+	case jkt == "":
+		return nil, ErrInvalidToken("Token is not DPoP-bound")
+	}
+
+	proofs := req.Header[http.CanonicalHeaderKey("DPoP")]
+	if len(proofs) != 1 {
+		return nil, ErrInvalidDPoPProof("expected exactly one DPoP header", "count", len(proofs))
+	}
+	key, err := v.verifyProof(proofs[0], req, rawToken)
+	if err != nil {
+		return nil, ErrInvalidDPoPProof(err.Error())
+	}
+
+	if tp, _ := Thumbprint(key); tp != jkt {
+		return nil, ErrInvalidToken("Token is not bound to the DPoP key", "jkt", tp)
+	}
+	return key, nil
+}
+
+// verifyProof verifies a DPoP proof's signature using the JWK in its header
+// and checks its claims against the request. It returns the proof's key.
+func (v *DPoPVerifier) verifyProof(proof string, req *http.Request, rawToken string) (interface{}, error) {
+	var key interface{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(proof, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, fmt.Errorf("proof has typ %q; expected \"dpop+jwt\"", typ)
+		}
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil || token.Header["jwk"] == nil {
+			return nil, errors.New("proof has no jwk")
+		}
+		jwk, err := ParseJWK(raw)
+		if err != nil {
+			return nil, err
+		}
+		switch jwk.Key.(type) {
+		case privateKey, []byte:
+			return nil, errors.New("proof's jwk is not a public key")
+		}
+		alg, _ := token.Header["alg"].(string)
+		if _, err := alg2method(alg, jwk.Key); err != nil {
+			return nil, err
+		}
+		key = jwk.Key
+		return key, nil
+	})
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+		err = ve.Inner
+	}
+	if err != nil {
+		return nil, err
+	}
+	claims := Claims(token.Claims.(jwt.MapClaims))
+
+	if htm := claims.String("htm"); htm != req.Method {
+		return nil, fmt.Errorf("proof is for method %q, not %q", htm, req.Method)
+	}
+	if htu := claims.String("htu"); !sameURL(htu, v.requestURL(req)) {
+		return nil, fmt.Errorf("proof is for URL %q, not %q", htu, v.requestURL(req))
+	}
+
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultDPoPMaxAge
+	}
+	iat, now := claims.IssuedAt(), time.Now()
+	if _, ok := claims["iat"]; !ok || iat.Before(now.Add(-maxAge)) || iat.After(now.Add(maxAge)) {
+		return nil, errors.New("proof is too old or too new")
+	}
+
+	sum := sha256.Sum256([]byte(rawToken))
+	if claims.String("ath") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("proof's ath does not match the token")
+	}
+
+	jti := claims.String("jti")
+	if jti == "" {
+		return nil, errors.New("proof has no jti")
+	}
+	if !v.remember(jti, iat.Add(maxAge), now) {
+		return nil, errors.New("proof has already been used")
+	}
+
+	return key, nil
+}
+
+// remember records that a proof has been used, and reports whether it was
+// used before. Proofs are remembered until they are too old to be accepted.
+//
+// Proofs are forgotten in the order they were seen, which is roughly the
+// order in which they expire; a proof stays behind an older one that has not
+// expired yet, so it may be remembered for up to twice MaxAge.
+func (v *DPoPVerifier) remember(jti string, until, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.seen == nil {
+		v.seen = map[string]bool{}
+		v.order = list.New()
+	}
+	for front := v.order.Front(); front != nil; front = v.order.Front() {
+		proof := front.Value.(*usedProof)
+		if !now.After(proof.until) {
+			break
+		}
+		v.order.Remove(front)
+		delete(v.seen, proof.jti)
+	}
+	if v.seen[jti] {
+		return false
+	}
+	v.seen[jti] = true
+	v.order.PushBack(&usedProof{jti, until})
+	return true
+}
+
+func (v *DPoPVerifier) requestURL(req *http.Request) string {
+	if v.RequestURL != nil {
+		return v.RequestURL(req)
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.Path
+}
+
+// sameURL compares URLs as RFC 9449 requires: ignoring query and fragment,
+// and ignoring case in the scheme and host.
+func sameURL(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host) && ua.EscapedPath() == ub.EscapedPath()
+}
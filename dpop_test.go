@@ -0,0 +1,130 @@
+package jwtauth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	jwtpkg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("DPoP", func() {
+	var verifier *jwtauth.DPoPVerifier
+	var stack goa.Handler
+	var req *http.Request
+	var dpopKey interface{}
+	var accessToken string
+
+	// makeProof creates a DPoP proof signed by key, with claims that match req
+	// unless overridden by keyvals.
+	makeProof := func(key interface{}, keyvals ...interface{}) string {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims := jwtpkg.MapClaims{
+			"htm": "GET",
+			"htu": "http://example.com/things",
+			"iat": time.Now().Unix(),
+			"jti": time.Now().String(),
+			"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+		}
+		for i := 0; i < len(keyvals); i += 2 {
+			claims[keyvals[i].(string)] = keyvals[i+1]
+		}
+
+		var jwk map[string]interface{}
+		data, err := json.Marshal(&jwtauth.JWK{Key: key})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(json.Unmarshal(data, &jwk)).Should(Succeed())
+
+		token := jwtpkg.NewWithClaims(jwtpkg.SigningMethodES256, claims)
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = jwk
+		proof, err := token.SignedString(key)
+		Ω(err).ShouldNot(HaveOccurred())
+		return proof
+	}
+
+	authenticate := func(scheme, proof string) error {
+		req.Header.Set("Authorization", scheme+" "+accessToken)
+		req.Header.Del("DPoP")
+		if proof != "" {
+			req.Header.Set("DPoP", proof)
+		}
+		return stack(context.Background(), httptest.NewRecorder(), req)
+	}
+
+	BeforeEach(func() {
+		jkt, err := jwtauth.Thumbprint(ecKey1)
+		Ω(err).ShouldNot(HaveOccurred())
+		accessToken, err = jwtauth.NewToken(hmacKey1, jwtauth.NewClaims(
+			"iss", "alice", "sub", "bob", "exp", time.Now().Add(time.Hour).Unix(),
+			"cnf", map[string]interface{}{"jkt": jkt},
+		))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		req, _ = http.NewRequest("GET", "http://example.com/things?page=2", nil)
+		dpopKey = nil
+		verifier = &jwtauth.DPoPVerifier{}
+		stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			dpopKey = jwtauth.ContextDPoPKey(ctx)
+			return nil
+		}
+		stack = jwtauth.AuthenticateWithOptions(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1},
+			jwtauth.DPoP(verifier))(stack)
+	})
+
+	It("accepts valid proofs", func() {
+		Ω(authenticate("DPoP", makeProof(ecKey1))).Should(Succeed())
+		Ω(dpopKey).Should(Equal(&ecKey1.PublicKey))
+	})
+
+	It("rejects replayed proofs", func() {
+		proof := makeProof(ecKey1)
+		Ω(authenticate("DPoP", proof)).Should(Succeed())
+		Ω(authenticate("DPoP", proof)).Should(HaveDetailSubstring("already been used"))
+	})
+
+	It("rejects proofs that do not match the request", func() {
+		Ω(authenticate("DPoP", makeProof(ecKey1, "htm", "POST"))).Should(HaveDetailSubstring("method"))
+		Ω(authenticate("DPoP", makeProof(ecKey1, "htu", "http://example.com/other"))).Should(HaveDetailSubstring("URL"))
+		Ω(authenticate("DPoP", makeProof(ecKey1, "iat", time.Now().Add(-time.Hour).Unix()))).Should(HaveDetailSubstring("too old"))
+		Ω(authenticate("DPoP", makeProof(ecKey1, "ath", "nope"))).Should(HaveDetailSubstring("ath"))
+		Ω(authenticate("DPoP", makeProof(ecKey1, "jti", ""))).Should(HaveDetailSubstring("jti"))
+	})
+
+	It("reports invalid proofs as such", func() {
+		err := authenticate("DPoP", "")
+		Ω(err).Should(HaveResponseStatus(401))
+		Ω(err.(*goa.ErrorResponse).Code).Should(Equal("invalid_dpop_proof"))
+
+		err = authenticate("DPoP", makeProof(ecKey1)+"x")
+		Ω(err.(*goa.ErrorResponse).Code).Should(Equal("invalid_dpop_proof"))
+	})
+
+	It("rejects proofs from other keys", func() {
+		err := authenticate("DPoP", makeProof(ecKey2))
+		Ω(err).Should(HaveDetailSubstring("not bound to the DPoP key"))
+		Ω(err.(*goa.ErrorResponse).Code).Should(Equal("invalid_token"))
+	})
+
+	It("rejects bound tokens presented as bearer tokens", func() {
+		Ω(authenticate("Bearer", makeProof(ecKey1))).Should(HaveDetailSubstring("bearer token"))
+	})
+
+	It("accepts unbound bearer tokens unless DPoP is required", func() {
+		accessToken = makeToken("alice", "bob", hmacKey1)
+		Ω(authenticate("Bearer", "")).Should(Succeed())
+		Ω(dpopKey).Should(BeNil())
+		Ω(authenticate("DPoP", makeProof(ecKey1))).Should(HaveDetailSubstring("not DPoP-bound"))
+
+		verifier.Required = true
+		Ω(authenticate("Bearer", "")).Should(HaveDetailSubstring("required"))
+	})
+})
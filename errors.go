@@ -11,6 +11,14 @@ var (
 	// its signature could not be verified.
 	ErrInvalidToken = goa.NewErrorClass("invalid_token", 401)
 
+	// ErrInvalidDPoPProof indicates that the request's DPoP proof (RFC 9449)
+	// was missing, malformed or did not match the request.
+	ErrInvalidDPoPProof = goa.NewErrorClass("invalid_dpop_proof", 401)
+
+	// ErrUnavailable indicates that a server that jwtauth consults to verify
+	// tokens, such as an introspection endpoint, failed to answer.
+	ErrUnavailable = goa.NewErrorClass("unavailable", 503)
+
 	// ErrAuthorizationFailed indicates that the request's JWT was well-formed
 	// and valid, but the user is not authorized to perform the requested
 	// operation.
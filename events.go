@@ -0,0 +1,121 @@
+package jwtauth
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// EventType identifies the kind of change described by a KeystoreEvent.
+	EventType string
+
+	// KeystoreEvent describes a change to the keys that a keystore trusts.
+	KeystoreEvent struct {
+		// Type is the kind of change.
+		Type EventType
+		// Issuer is the issuer (or issuer pattern) whose trust changed.
+		Issuer string
+		// Key is the key that was affected; for EventRotated it is the new
+		// key, and for EventRefreshFailed it is nil.
+		Key interface{}
+		// Fingerprint identifies Key without revealing it; see Fingerprint.
+		Fingerprint string
+		// Time is when the change happened.
+		Time time.Time
+		// Err is the reason for an EventRefreshFailed.
+		Err error
+	}
+
+	// subscriber is implemented by keystores that emit events.
+	subscriber interface {
+		Subscribe(EventFunc) (unsubscribe func())
+	}
+
+	// observers is a set of listeners that are interested in keystore events.
+	// The zero value is ready to use.
+	observers struct {
+		mu        sync.Mutex
+		next      int
+		listeners map[int]EventFunc
+	}
+)
+
+const (
+	// EventTrusted indicates that an issuer became trusted.
+	EventTrusted EventType = "trusted"
+	// EventRevoked indicates that an issuer is no longer trusted.
+	EventRevoked EventType = "revoked"
+	// EventRotated indicates that a trusted issuer's key changed.
+	EventRotated EventType = "rotated"
+	// EventExpired indicates that trust in a key ended because its validity
+	// period elapsed; see NamedKeystore.TrustUntil.
+	EventExpired EventType = "expired"
+	// EventRefreshFailed indicates that a keystore could not refresh its keys
+	// from their source; the keys that it trusted remain trusted.
+	EventRefreshFailed EventType = "refresh-failed"
+)
+
+// subscribe adds a listener and returns a function that removes it.
+func (o *observers) subscribe(listener EventFunc) (unsubscribe func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.listeners == nil {
+		o.listeners = map[int]EventFunc{}
+	}
+	id := o.next
+	o.next++
+	o.listeners[id] = listener
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(o.listeners, id)
+	}
+}
+
+// emit calls every listener with an event. Callers must not hold any locks
+// that a listener might need, e.g. by calling back into the keystore.
+func (o *observers) emit(ev KeystoreEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Key != nil && ev.Fingerprint == "" {
+		ev.Fingerprint = Fingerprint(ev.Key)
+	}
+
+	o.mu.Lock()
+	listeners := make([]EventFunc, 0, len(o.listeners))
+	for _, l := range o.listeners {
+		listeners = append(listeners, l)
+	}
+	o.mu.Unlock()
+
+	for _, l := range listeners {
+		l(ev)
+	}
+}
+
+// diffKeys compares two generations of a keystore's keys and describes the
+// differences as events, sorted by issuer.
+func diffKeys(old, new map[string]interface{}) []KeystoreEvent {
+	var events []KeystoreEvent
+	for iss, key := range new {
+		if oldKey, ok := old[iss]; !ok {
+			events = append(events, KeystoreEvent{Type: EventTrusted, Issuer: iss, Key: key})
+		} else if !reflect.DeepEqual(oldKey, key) {
+			events = append(events, KeystoreEvent{Type: EventRotated, Issuer: iss, Key: key})
+		}
+	}
+	for iss, key := range old {
+		if _, ok := new[iss]; !ok {
+			events = append(events, KeystoreEvent{Type: EventRevoked, Issuer: iss, Key: key})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Issuer < events[j].Issuer
+	})
+	return events
+}
@@ -0,0 +1,245 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Identifiers defined by RFC 8693 (OAuth 2.0 Token Exchange).
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+type (
+	// TokenExchange is an http.Handler that implements the token exchange
+	// grant of RFC 8693, which turns a token issued to one party into a new
+	// token for another audience. Use it to build a small security token
+	// service:
+	//
+	//     sts := &jwtauth.TokenExchange{
+	//       Keystore:  store,
+	//       Issuer:    "https://sts.example.com",
+	//       Key:       stsKey,
+	//       Audiences: []string{"billing", "inventory"},
+	//     }
+	//     http.Handle("/token", sts)
+	//
+	// The subject_token (and actor_token, if any) is verified against
+	// Keystore exactly as the authentication middleware would verify it. The
+	// issued token is then built as by Delegation: it has the subject's "sub",
+	// an "act" claim that identifies the actor (if there is an actor_token),
+	// the requested audience, and the requested scopes -- which must be a
+	// subset of the subject's own scopes.
+	//
+	// TokenExchange does not authenticate its clients; if your policy
+	// requires it, wrap the handler in a middleware that does.
+	TokenExchange struct {
+		// Keystore verifies subject and actor tokens.
+		Keystore Keystore
+
+		// Options customize how subject and actor tokens are verified, e.g.
+		// to check them against a RevocationStore. The Extraction option has
+		// no effect.
+		Options []Option
+
+		// Issuer is the "iss" of issued tokens. It is required.
+		Issuer string
+
+		// Key signs issued tokens. It is required.
+		Key interface{}
+
+		// Audiences lists the audiences for which tokens may be issued; if it
+		// is not empty, every request must name at least one of them. If
+		// empty, any audience (or none) may be requested.
+		Audiences []string
+
+		// TTL is the lifetime of issued tokens, which never outlive the
+		// subject token. If zero, DefaultDelegationTTL is used.
+		TTL time.Duration
+
+		// Policy, if not nil, decides whether to issue a token with the
+		// specified claims. Policy may modify the claims. If it returns an
+		// error, the request is rejected with "invalid_grant".
+		Policy func(subject, actor, issued Claims) error
+	}
+
+	// exchangeError is an OAuth 2.0 error response (RFC 6749 Section 5.2).
+	exchangeError struct {
+		Code        string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+
+	// exchangeResponse is a token exchange response (RFC 8693 Section 2.2).
+	exchangeResponse struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		TokenType       string `json:"token_type"`
+		ExpiresIn       int64  `json:"expires_in"`
+		Scope           string `json:"scope,omitempty"`
+	}
+)
+
+// ServeHTTP implements http.Handler#ServeHTTP
+func (te *TokenExchange) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, &exchangeError{"invalid_request", "method must be POST"})
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, &exchangeError{"invalid_request", err.Error()})
+		return
+	}
+
+	resp, err := te.exchange(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Code == "server_error" {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// exchange validates a token exchange request and issues a token.
+func (te *TokenExchange) exchange(req *http.Request) (*exchangeResponse, *exchangeError) {
+	if te.Issuer == "" || te.Key == nil {
+		return nil, &exchangeError{"server_error", "token exchange has no Issuer or Key"}
+	}
+
+	form := req.PostForm
+	if gt := form.Get("grant_type"); gt != GrantTypeTokenExchange {
+		return nil, &exchangeError{"unsupported_grant_type", fmt.Sprintf("grant_type %q is not supported", gt)}
+	}
+	switch rtt := form.Get("requested_token_type"); rtt {
+	case "", TokenTypeAccessToken, TokenTypeJWT:
+	default:
+		return nil, &exchangeError{"invalid_request", fmt.Sprintf("requested_token_type %q is not supported", rtt)}
+	}
+
+	o := &options{}
+	for _, opt := range te.Options {
+		opt(o)
+	}
+
+	subject, err := te.verify(req, o, "subject_token", true)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := te.verify(req, o, "actor_token", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var audience []string
+	audience = append(audience, form["audience"]...)
+	audience = append(audience, form["resource"]...)
+	if len(audience) == 0 && len(te.Audiences) > 0 {
+		return nil, &exchangeError{"invalid_target", "audience is required"}
+	}
+	for _, aud := range audience {
+		if !te.allowsAudience(aud) {
+			return nil, &exchangeError{"invalid_target", fmt.Sprintf("audience %q is not allowed", aud)}
+		}
+	}
+
+	var scopes []string
+	if scope := form.Get("scope"); scope != "" {
+		scopes = strings.Fields(scope)
+		held := subject.Strings(ScopesClaim)
+		for _, s := range scopes {
+			if !contains(held, s) {
+				return nil, &exchangeError{"invalid_scope", fmt.Sprintf("scope %q is not held by the subject", s)}
+			}
+		}
+	}
+
+	now := time.Now()
+	delegation := &Delegation{
+		Issuer:   te.Issuer,
+		Audience: audience,
+		Scopes:   scopes,
+		TTL:      te.TTL,
+	}
+	if actor != nil {
+		if delegation.Actor = actor.Subject(); delegation.Actor == "" {
+			return nil, &exchangeError{"invalid_request", "actor_token has no subject"}
+		}
+	}
+	claims, dErr := delegation.Claims(subject, now)
+	if dErr != nil {
+		return nil, &exchangeError{"invalid_grant", dErr.Error()}
+	}
+	if te.Policy != nil {
+		if pErr := te.Policy(subject, actor, claims); pErr != nil {
+			return nil, &exchangeError{"invalid_grant", pErr.Error()}
+		}
+	}
+
+	token, tErr := NewToken(te.Key, claims)
+	if tErr != nil {
+		return nil, &exchangeError{"server_error", tErr.Error()}
+	}
+	return &exchangeResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeJWT,
+		TokenType:       "Bearer",
+		ExpiresIn:       claims.ExpiresAt().Unix() - now.Unix(),
+		Scope:           strings.Join(claims.Strings(ScopesClaim), " "),
+	}, nil
+}
+
+// verify verifies the token in the named form field, and returns its claims.
+// It returns no claims and no error if an optional token is absent.
+func (te *TokenExchange) verify(req *http.Request, o *options, name string, required bool) (Claims, *exchangeError) {
+	token := req.PostForm.Get(name)
+	if token == "" {
+		if required {
+			return nil, &exchangeError{"invalid_request", name + " is required"}
+		}
+		return nil, nil
+	}
+	switch tt := req.PostForm.Get(name + "_type"); tt {
+	case TokenTypeAccessToken, TokenTypeJWT:
+	default:
+		return nil, &exchangeError{"invalid_request", fmt.Sprintf("%s_type %q is not supported", name, tt)}
+	}
+
+	claims, _, err := o.authenticate(req.Context(), te.Keystore, token)
+	if err != nil {
+		return nil, &exchangeError{"invalid_grant", fmt.Sprintf("%s is invalid: %s", name, err)}
+	}
+	if claims == nil {
+		claims = Claims{}
+	}
+	return claims, nil
+}
+
+func (te *TokenExchange) allowsAudience(aud string) bool {
+	return len(te.Audiences) == 0 || contains(te.Audiences, aud)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,171 @@
+package jwtauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("TokenExchange", func() {
+	var sts *jwtauth.TokenExchange
+	var subjectToken string
+
+	exchange := func(form url.Values) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		sts.ServeHTTP(w, req)
+		Ω(w.Header().Get("Cache-Control")).Should(Equal("no-store"))
+		var body map[string]interface{}
+		Ω(json.Unmarshal(w.Body.Bytes(), &body)).Should(Succeed())
+		return w.Code, body
+	}
+
+	request := func(keyvals ...string) url.Values {
+		form := url.Values{
+			"grant_type":         {jwtauth.GrantTypeTokenExchange},
+			"subject_token":      {subjectToken},
+			"subject_token_type": {jwtauth.TokenTypeJWT},
+		}
+		for i := 0; i < len(keyvals); i += 2 {
+			form.Set(keyvals[i], keyvals[i+1])
+		}
+		return form
+	}
+
+	// issued verifies an issued token and returns its claims.
+	issued := func(body map[string]interface{}) jwtauth.Claims {
+		store := &jwtauth.NamedKeystore{}
+		Ω(store.Trust("sts", rsaKey2.Public())).Should(Succeed())
+		var claims jwtauth.Claims
+		stack := jwtauth.Authenticate(commonScheme, store)(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				claims = jwtauth.ContextClaims(ctx)
+				return nil
+			})
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		setBearerHeader(req, body["access_token"].(string))
+		Ω(stack(context.Background(), httptest.NewRecorder(), req)).Should(Succeed())
+		return claims
+	}
+
+	BeforeEach(func() {
+		sts = &jwtauth.TokenExchange{
+			Keystore:  &jwtauth.SimpleKeystore{Key: hmacKey1},
+			Issuer:    "sts",
+			Key:       rsaKey2,
+			Audiences: []string{"billing", "inventory"},
+			TTL:       time.Minute,
+		}
+		subjectToken = makeToken("login", "alice", hmacKey1, "read", "write")
+	})
+
+	It("exchanges tokens", func() {
+		status, body := exchange(request("audience", "billing", "scope", "read"))
+		Ω(status).Should(Equal(200))
+		Ω(body).Should(HaveKeyWithValue("issued_token_type", jwtauth.TokenTypeJWT))
+		Ω(body).Should(HaveKeyWithValue("token_type", "Bearer"))
+		Ω(body).Should(HaveKeyWithValue("scope", "read"))
+		Ω(body["expires_in"]).Should(BeNumerically("<=", 60))
+
+		claims := issued(body)
+		Ω(claims.Issuer()).Should(Equal("sts"))
+		Ω(claims.Subject()).Should(Equal("alice"))
+		Ω(claims.Strings("aud")).Should(Equal([]string{"billing"}))
+		Ω(claims.Strings(jwtauth.ScopesClaim)).Should(Equal([]string{"read"}))
+		Ω(claims.Actors()).Should(BeEmpty())
+	})
+
+	It("records the actor", func() {
+		actorToken := makeToken("login", "svc-a", hmacKey1)
+		status, body := exchange(request("audience", "billing", "actor_token", actorToken, "actor_token_type", jwtauth.TokenTypeAccessToken))
+		Ω(status).Should(Equal(200))
+		Ω(issued(body).Actors()).Should(Equal([]string{"svc-a"}))
+	})
+
+	It("rejects invalid requests", func() {
+		status, body := exchange(request("grant_type", "password"))
+		Ω(status).Should(Equal(400))
+		Ω(body).Should(HaveKeyWithValue("error", "unsupported_grant_type"))
+
+		_, body = exchange(request("subject_token", ""))
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_request"))
+
+		_, body = exchange(request("subject_token_type", "urn:ietf:params:oauth:token-type:saml2"))
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_request"))
+	})
+
+	It("rejects untrusted subject tokens", func() {
+		subjectToken = makeToken("login", "alice", hmacKey2)
+		_, body := exchange(request())
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_grant"))
+	})
+
+	It("rejects tokens that do not suit the issuer's key", func() {
+		sts.Keystore = &jwtauth.SimpleKeystore{Key: rsaKey1.Public()}
+		var body map[string]interface{}
+		Ω(func() { _, body = exchange(request("audience", "billing")) }).ShouldNot(Panic())
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_grant"))
+	})
+
+	It("enforces the audience policy", func() {
+		_, body := exchange(request("audience", "payroll"))
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_target"))
+
+		_, body = exchange(request())
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_target"))
+		Ω(body["error_description"]).Should(ContainSubstring("audience is required"))
+
+		sts.Audiences = nil
+		status, body := exchange(request())
+		Ω(status).Should(Equal(200))
+		Ω(issued(body).Strings("aud")).Should(BeEmpty())
+	})
+
+	It("only narrows scopes", func() {
+		_, body := exchange(request("audience", "billing", "scope", "read admin"))
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_scope"))
+	})
+
+	It("applies a custom policy", func() {
+		sts.Policy = func(subject, actor, issued jwtauth.Claims) error {
+			if actor == nil {
+				return errors.New("an actor is required")
+			}
+			return nil
+		}
+		_, body := exchange(request("audience", "billing"))
+		Ω(body).Should(HaveKeyWithValue("error", "invalid_grant"))
+		Ω(body["error_description"]).Should(ContainSubstring("actor is required"))
+	})
+
+	It("refuses to issue tokens without an issuer", func() {
+		sts.Issuer = ""
+		status, body := exchange(request("audience", "billing"))
+		Ω(status).Should(Equal(500))
+		Ω(body).Should(HaveKeyWithValue("error", "server_error"))
+		Ω(body).ShouldNot(HaveKey("access_token"))
+	})
+
+	It("fails with a server error when it cannot sign tokens", func() {
+		sts.Key = 42
+		status, body := exchange(request("audience", "billing"))
+		Ω(status).Should(Equal(500))
+		Ω(body).Should(HaveKeyWithValue("error", "server_error"))
+	})
+
+	It("requires POST", func() {
+		w := httptest.NewRecorder()
+		sts.ServeHTTP(w, httptest.NewRequest("GET", "/token", nil))
+		Ω(w.Code).Should(Equal(405))
+	})
+})
@@ -0,0 +1,152 @@
+package jwtauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// FileRevocationStore is a concurrency-safe RevocationStore that reads
+	// revocations from a JSON file, which lists revoked token IDs, session IDs
+	// and subjects (grouped by issuer):
+	//
+	//     {
+	//       "jti": ["3d8f0e4a", "c0ffee00"],
+	//       "sid": ["session-42"],
+	//       "sub": {"https://login.example.com": {"alice": 1760000000}}
+	//     }
+	//
+	// Each subject maps to a NumericDate (seconds since the epoch) before
+	// which all of the tokens that the issuer issued to the subject are
+	// revoked.
+	//
+	// Call Reload to reread the file, or Watch to reread it periodically. If
+	// the file cannot be read or parsed, the previous revocations remain in
+	// effect.
+	FileRevocationStore struct {
+		// Path is the location of the revocation file.
+		Path string
+
+		// ErrorLog receives messages about files that could not be loaded. If
+		// nil, messages are logged using the log package's standard logger.
+		ErrorLog *log.Logger
+
+		mu      sync.RWMutex
+		current *MemoryRevocationStore
+		hash    [sha256.Size]byte
+		poller
+	}
+
+	// revocationFile is the format of a FileRevocationStore's file.
+	revocationFile struct {
+		Tokens   []string                    `json:"jti"`
+		Sessions []string                    `json:"sid"`
+		Subjects map[string]map[string]int64 `json:"sub"`
+	}
+)
+
+// NewFileRevocationStore creates a FileRevocationStore, loads the file at
+// path, and (if interval is positive) starts watching it for changes. It
+// returns an error if the file cannot be loaded.
+func NewFileRevocationStore(path string, interval time.Duration) (*FileRevocationStore, error) {
+	fs := &FileRevocationStore{Path: path}
+	if err := fs.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		fs.Watch(interval)
+	}
+	return fs, nil
+}
+
+// TokenRevoked implements jwtauth.RevocationStore#TokenRevoked
+func (fs *FileRevocationStore) TokenRevoked(jti string) bool {
+	return fs.store().TokenRevoked(jti)
+}
+
+// SubjectRevokedBefore implements jwtauth.RevocationStore#SubjectRevokedBefore
+func (fs *FileRevocationStore) SubjectRevokedBefore(iss, sub string) time.Time {
+	return fs.store().SubjectRevokedBefore(iss, sub)
+}
+
+// SessionRevoked implements jwtauth.RevocationStore#SessionRevoked
+func (fs *FileRevocationStore) SessionRevoked(sid string) bool {
+	return fs.store().SessionRevoked(sid)
+}
+
+// Reload rereads the file and replaces the revocations in a single atomic
+// step. It returns an error if the file cannot be read or parsed, in which
+// case the revocations are left unchanged.
+func (fs *FileRevocationStore) Reload() error {
+	data, err := ioutil.ReadFile(fs.Path)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(data)
+	fs.mu.RLock()
+	unchanged := fs.current != nil && hash == fs.hash
+	fs.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	var doc revocationFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("%s: %s", fs.Path, err)
+	}
+
+	fresh := &MemoryRevocationStore{}
+	for _, jti := range doc.Tokens {
+		fresh.RevokeToken(jti, time.Time{})
+	}
+	for _, sid := range doc.Sessions {
+		fresh.RevokeSession(sid, time.Time{})
+	}
+	for iss, subjects := range doc.Subjects {
+		for sub, before := range subjects {
+			fresh.RevokeSubject(iss, sub, time.Unix(before, 0))
+		}
+	}
+
+	fs.mu.Lock()
+	fs.current, fs.hash = fresh, hash
+	fs.mu.Unlock()
+
+	return nil
+}
+
+// Watch starts rereading the file every interval, until Close is called.
+// Errors are logged. Calling Watch again replaces the previous schedule.
+func (fs *FileRevocationStore) Watch(interval time.Duration) {
+	fs.poller.watch(interval, func() {
+		if err := fs.Reload(); err != nil {
+			logf(fs.ErrorLog, "jwtauth: cannot reload revocations from %s: %s", fs.Path, err)
+		}
+	})
+}
+
+// Close stops watching the file. The revocations that were loaded remain in
+// effect.
+func (fs *FileRevocationStore) Close() {
+	fs.poller.close()
+}
+
+// store returns the current revocations.
+func (fs *FileRevocationStore) store() *MemoryRevocationStore {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.current == nil {
+		return &MemoryRevocationStore{}
+	}
+	return fs.current
+}
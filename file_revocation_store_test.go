@@ -0,0 +1,80 @@
+package jwtauth_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("FileRevocationStore", func() {
+	var dir, path string
+	var store *jwtauth.FileRevocationStore
+
+	write := func(data string) {
+		Ω(ioutil.WriteFile(path, []byte(data), 0600)).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "jwtauth")
+		Ω(err).ShouldNot(HaveOccurred())
+		path = filepath.Join(dir, "revoked.json")
+
+		write(`{"jti": ["abc"], "sid": ["s1"], "sub": {"login": {"alice": 1500000000}}}`)
+		store, err = jwtauth.NewFileRevocationStore(path, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		store.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("loads revocations", func() {
+		Ω(store.TokenRevoked("abc")).Should(BeTrue())
+		Ω(store.TokenRevoked("def")).Should(BeFalse())
+		Ω(store.SessionRevoked("s1")).Should(BeTrue())
+		Ω(store.SubjectRevokedBefore("login", "alice")).Should(Equal(time.Unix(1500000000, 0)))
+		Ω(store.SubjectRevokedBefore("login", "bob")).Should(BeZero())
+		Ω(store.SubjectRevokedBefore("partner", "alice")).Should(BeZero())
+	})
+
+	It("replaces revocations on reload", func() {
+		write(`{"jti": ["def"]}`)
+		Ω(store.Reload()).Should(Succeed())
+		Ω(store.TokenRevoked("abc")).Should(BeFalse())
+		Ω(store.TokenRevoked("def")).Should(BeTrue())
+		Ω(store.SessionRevoked("s1")).Should(BeFalse())
+	})
+
+	It("keeps its revocations if the file is bad", func() {
+		write(`{"jti": "abc"}`)
+		Ω(store.Reload()).ShouldNot(Succeed())
+		write(`{"jtis": ["abc"]}`)
+		Ω(store.Reload()).ShouldNot(Succeed())
+		Ω(os.Remove(path)).Should(Succeed())
+		Ω(store.Reload()).ShouldNot(Succeed())
+
+		Ω(store.TokenRevoked("abc")).Should(BeTrue())
+	})
+
+	It("watches for changes", func() {
+		watched, err := jwtauth.NewFileRevocationStore(path, 10*time.Millisecond)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer watched.Close()
+
+		write(`{"jti": ["abc", "def"]}`)
+
+		Eventually(func() bool { return watched.TokenRevoked("def") }).Should(BeTrue())
+	})
+
+	It("refuses to load a missing file", func() {
+		_, err := jwtauth.NewFileRevocationStore(filepath.Join(dir, "missing.json"), 0)
+		Ω(err).Should(HaveOccurred())
+	})
+})
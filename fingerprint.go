@@ -0,0 +1,98 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Fingerprint computes a digest that identifies a key without revealing it,
+// which makes it safe to log. It is the hex-encoded SHA-256 digest of a
+// public key's PKIX (DER) encoding, or of an HMAC secret; private keys have
+// the same fingerprint as their public key. The fingerprint of a key set
+// lists the fingerprint of each key, separated by commas.
+//
+// Fingerprint returns "" if it does not recognize the key's type.
+//
+// The fingerprint of an HMAC secret is an unsalted digest that can confirm a
+// guessed secret, so keep it out of anything clients can see.
+func Fingerprint(key interface{}) string {
+	var material []byte
+	switch kt := key.(type) {
+	case []byte:
+		material = kt
+	case string:
+		material = []byte(kt)
+	case privateKey:
+		return Fingerprint(kt.Public())
+	case []interface{}:
+		prints := make([]string, len(kt))
+		for i, k := range kt {
+			prints[i] = Fingerprint(k)
+		}
+		return strings.Join(prints, ",")
+	case *JWKSet:
+		prints := make([]string, len(kt.Keys))
+		for i, jwk := range kt.Keys {
+			prints[i] = Fingerprint(jwk.Key)
+		}
+		return strings.Join(prints, ",")
+	case *JWK:
+		return Fingerprint(kt.Key)
+	default:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return ""
+		}
+		material = der
+	}
+
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:])
+}
+
+// Thumbprint computes the JWK thumbprint of a key as specified by RFC 7638:
+// the base64url-encoded SHA-256 digest of the key's required JWK members.
+// Unlike Fingerprint, the result is interoperable with other JOSE libraries;
+// for instance, it is often used as a "kid".
+//
+// Thumbprint accepts the same key types as NamedKeystore.Trust, plus *JWK,
+// but not key sets. Private keys have the same thumbprint as their public
+// key.
+func Thumbprint(key interface{}) (string, error) {
+	jwk, ok := key.(*JWK)
+	if !ok {
+		jwk = &JWK{Key: key}
+	}
+	raw, err := (&JWK{Key: jwk.Key}).wire()
+	if err != nil {
+		return "", err
+	}
+
+	members := map[string]string{"kty": raw.Kty}
+	switch raw.Kty {
+	case "RSA":
+		members["e"], members["n"] = raw.E, raw.N
+	case "EC":
+		members["crv"], members["x"], members["y"] = raw.Crv, raw.X, raw.Y
+	case "OKP":
+		members["crv"], members["x"] = raw.Crv, raw.X
+	case "oct":
+		members["k"] = raw.K
+	default:
+		return "", fmt.Errorf("unsupported key type %s", raw.Kty)
+	}
+
+	// encoding/json sorts map keys, which yields the lexicographic order
+	// that RFC 7638 requires.
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,74 @@
+package jwtauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("Fingerprint()", func() {
+	It("identifies keys", func() {
+		for _, key := range []interface{}{hmacKey1, &rsaKey1.PublicKey, &ecKey1.PublicKey, edKey1.Public()} {
+			Ω(jwtauth.Fingerprint(key)).Should(MatchRegexp("^[0-9a-f]{64}$"))
+		}
+		Ω(jwtauth.Fingerprint(rsaKey1)).ShouldNot(Equal(jwtauth.Fingerprint(rsaKey2)))
+		Ω(jwtauth.Fingerprint(string(hmacKey1))).Should(Equal(jwtauth.Fingerprint(hmacKey1)))
+	})
+
+	It("does not distinguish private keys from public", func() {
+		Ω(jwtauth.Fingerprint(rsaKey1)).Should(Equal(jwtauth.Fingerprint(&rsaKey1.PublicKey)))
+		Ω(jwtauth.Fingerprint(ecKey1)).Should(Equal(jwtauth.Fingerprint(&ecKey1.PublicKey)))
+		Ω(jwtauth.Fingerprint(edKey1)).Should(Equal(jwtauth.Fingerprint(edKey1.Public())))
+	})
+
+	It("lists the keys in a set", func() {
+		set := &jwtauth.JWKSet{Keys: []*jwtauth.JWK{{Key: rsaKey1}, {Key: ecKey1}}}
+		expected := jwtauth.Fingerprint(rsaKey1) + "," + jwtauth.Fingerprint(ecKey1)
+		Ω(jwtauth.Fingerprint(set)).Should(Equal(expected))
+		Ω(jwtauth.Fingerprint([]interface{}{rsaKey1, ecKey1})).Should(Equal(expected))
+	})
+
+	It("ignores unknown types", func() {
+		Ω(jwtauth.Fingerprint(42)).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("Thumbprint()", func() {
+	It("computes the RFC 7638 example", func() {
+		jwk, err := jwtauth.ParseJWK([]byte(`{
+			"kty": "RSA",
+			"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+			"e": "AQAB",
+			"alg": "RS256",
+			"kid": "2011-04-29"
+		}`))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		tp, err := jwtauth.Thumbprint(jwk)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tp).Should(Equal("NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"))
+
+		tp, err = jwtauth.Thumbprint(jwk.Key)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tp).Should(Equal("NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"))
+	})
+
+	It("supports every key type", func() {
+		for _, key := range []interface{}{hmacKey1, rsaKey1, ecKey1, edKey1} {
+			tp, err := jwtauth.Thumbprint(key)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(tp).Should(HaveLen(43))
+		}
+	})
+
+	It("does not distinguish private keys from public", func() {
+		priv, _ := jwtauth.Thumbprint(ecKey1)
+		pub, _ := jwtauth.Thumbprint(&ecKey1.PublicKey)
+		Ω(priv).Should(Equal(pub))
+	})
+
+	It("rejects key sets", func() {
+		_, err := jwtauth.Thumbprint([]interface{}{rsaKey1})
+		Ω(err).Should(HaveOccurred())
+	})
+})
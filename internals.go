@@ -2,15 +2,15 @@ package jwtauth
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"strings"
 
 	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/goadesign/goa"
 )
 
 func parseTokenMetadata(tok string) []interface{} {
@@ -45,37 +45,62 @@ func parseTokenMetadata(tok string) []interface{} {
 	return ret
 }
 
-// parseToken does the gruntwork of extracting A JWT from a request.
-func parseToken(scheme *goa.JWTSecurity, store Keystore, exfn ExtractionFunc, req *http.Request) (*jwt.Token, error) {
-	// Extract the JWT from the request
-	tok, err := exfn(scheme, req)
+// isJWT determines whether a token looks like a JWT in compact serialization,
+// as opposed to an opaque token: three base64url segments, the first of which
+// is a JSON object.
+func isJWT(tok string) bool {
+	bits := strings.Split(tok, ".")
+	if len(bits) != 3 {
+		return false
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(bits[0])
 	if err != nil {
-		return nil, err
-	} else if tok == "" {
-		return nil, nil
+		return false
 	}
+	var header map[string]interface{}
+	return json.Unmarshal(rawHeader, &header) == nil
+}
+
+// parseToken does the gruntwork of verifying a JWT. If the keystore matched
+// the token's issuer against a pattern, it also returns the issuer's tenant.
+func parseToken(store Keystore, tok string) (*jwt.Token, string, error) {
+	var err error
 
 	// Parse the JWT and identify the issuer
-	var alg, iss string
+	var alg, iss, tenant string
 	var key interface{}
+	var candidates []interface{}
 	parsed, err := jwt.Parse(tok, func(token *jwt.Token) (interface{}, error) {
 		alg, _ = token.Header["alg"].(string)
 		iss, err = identifyIssuer(token)
 		if err != nil {
 			return nil, err
 		}
-		key = store.Get(iss)
+		key, tenant = lookupKey(store, iss)
 		if key == nil {
 			return nil, ErrInvalidToken("Untrusted", "issuer", iss)
 		}
-		return key, nil
+		candidates = candidateKeys(key, token.Header)
+		if len(candidates) == 0 {
+			return nil, ErrInvalidToken("Untrusted", "issuer", iss, "kid", token.Header["kid"], "alg", alg)
+		}
+		return candidates[0], nil
 	})
 
+	// if the issuer has several keys, try each until the signature verifies
+	tried := 1
+	for ; tried < len(candidates) && isSignatureInvalid(err); tried++ {
+		candidate := candidates[tried]
+		parsed, err = jwt.Parse(tok, func(*jwt.Token) (interface{}, error) {
+			return candidate, nil
+		})
+	}
+
 	// help clients with mystery errors caused by fast-and-loose key
-	// typing in crypto and dgrijalva/jwt-go
+	// typing in crypto and dgrijalva/jwt-go; the token is simply invalid,
+	// since a client can choose any alg it likes
 	if err != nil && strings.HasPrefix(err.Error(), "key is of invalid type") {
 		err = fmt.Errorf("%s (local keystore contains %T for issuer '%s' but JWT has alg=%s)", err.Error(), key, iss, alg)
-		panic(err)
 	}
 
 	if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
@@ -85,10 +110,109 @@ func parseToken(scheme *goa.JWTSecurity, store Keystore, exfn ExtractionFunc, re
 	}
 
 	if err != nil {
-		err = ErrInvalidToken(err.Error(), parseTokenMetadata(tok)...)
+		meta := parseTokenMetadata(tok)
+		// tell clients which key(s) we hold for the issuer, without
+		// revealing them
+		var fingerprint string
+		if len(candidates) > 0 {
+			fingerprint = publicFingerprint(candidates[:tried])
+		} else if key != nil {
+			fingerprint = publicFingerprint(key)
+		}
+		if fingerprint != "" {
+			meta = append(meta, "fingerprint", fingerprint)
+		}
+		err = ErrInvalidToken(err.Error(), meta...)
+		tenant = ""
+	}
+
+	return parsed, tenant, err
+}
+
+// publicFingerprint is like Fingerprint, but omits symmetric keys: their
+// fingerprint is an unsalted digest of the secret, which would let clients
+// check guesses offline. It returns "" if no key remains.
+func publicFingerprint(key interface{}) string {
+	var keys []interface{}
+	switch kt := key.(type) {
+	case []interface{}:
+		keys = kt
+	case *JWKSet:
+		for _, jwk := range kt.Keys {
+			keys = append(keys, jwk.Key)
+		}
+	default:
+		keys = []interface{}{key}
+	}
+
+	var public []interface{}
+	for _, k := range keys {
+		if jwk, ok := k.(*JWK); ok {
+			k = jwk.Key
+		}
+		switch k.(type) {
+		case []byte, string:
+		default:
+			public = append(public, k)
+		}
+	}
+	if len(public) == 0 {
+		return ""
+	}
+	return Fingerprint(public)
+}
+
+// lookupKey gets an issuer's key from a keystore, along with the issuer's
+// tenant if the keystore supports issuer patterns.
+func lookupKey(store Keystore, issuer string) (interface{}, string) {
+	if ms, ok := store.(interface {
+		Match(issuer string) (interface{}, string)
+	}); ok {
+		return ms.Match(issuer)
+	}
+	return store.Get(issuer), ""
+}
+
+// candidateKeys determines which of an issuer's keys could have signed a
+// token: only keys that suit the token's "alg" header are candidates and, if
+// the issuer has a *JWKSet, only keys that match its "kid" header.
+func candidateKeys(key interface{}, header map[string]interface{}) []interface{} {
+	alg, _ := header["alg"].(string)
+	kid, _ := header["kid"].(string)
+
+	var candidates []interface{}
+	switch kt := key.(type) {
+	case []interface{}:
+		for _, k := range kt {
+			if _, err := alg2method(alg, k); err == nil {
+				candidates = append(candidates, k)
+			}
+		}
+	case *JWKSet:
+		for _, jwk := range kt.Keys {
+			if kid != "" && jwk.KeyID != kid {
+				continue
+			}
+			if jwk.Algorithm != "" && jwk.Algorithm != alg {
+				continue
+			}
+			if _, err := alg2method(alg, jwk.Key); err == nil {
+				candidates = append(candidates, jwk.Key)
+			}
+		}
+	default:
+		if _, err := alg2method(alg, key); err == nil {
+			candidates = []interface{}{key}
+		}
 	}
+	return candidates
+}
 
-	return parsed, err
+// isSignatureInvalid determines whether jwt-go rejected a token because its
+// signature could not be verified.
+func isSignatureInvalid(err error) bool {
+	ve, ok := err.(*jwt.ValidationError)
+	return ok && ve.Errors&jwt.ValidationErrorSignatureInvalid != 0
 }
 
 // identifyIssuer inspects a JWT's claims to determine its issuer.
@@ -118,15 +242,78 @@ func identifyIssuer(token *jwt.Token) (string, error) {
 }
 
 // key2method determines a JWT SigningMethod that is suitable for the given key.
+// For ECDSA keys, the method is chosen according to the key's curve.
 func key2method(key interface{}) jwt.SigningMethod {
-	switch key.(type) {
+	switch kt := key.(type) {
 	case []byte, string:
 		return jwt.SigningMethodHS256
 	case rsa.PrivateKey, *rsa.PrivateKey, rsa.PublicKey, *rsa.PublicKey:
 		return jwt.SigningMethodRS256
-	case ecdsa.PrivateKey, *ecdsa.PrivateKey, ecdsa.PublicKey, *ecdsa.PublicKey:
+	case ecdsa.PrivateKey:
+		return curve2method(kt.Curve)
+	case *ecdsa.PrivateKey:
+		return curve2method(kt.Curve)
+	case ecdsa.PublicKey:
+		return curve2method(kt.Curve)
+	case *ecdsa.PublicKey:
+		return curve2method(kt.Curve)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return signingMethodEd25519
+	default:
+		return nil
+	}
+}
+
+// curve2method determines the ES SigningMethod that corresponds to an
+// elliptic curve, or nil if the curve is not one that JWA supports.
+func curve2method(curve elliptic.Curve) jwt.SigningMethod {
+	if curve == nil {
+		return nil
+	}
+	switch curve.Params().Name {
+	case "P-256":
 		return jwt.SigningMethodES256
+	case "P-384":
+		return jwt.SigningMethodES384
+	case "P-521":
+		return jwt.SigningMethodES512
 	default:
 		return nil
 	}
 }
+
+// alg2method finds the SigningMethod for a named JWA algorithm and verifies
+// that the given key can be used with it.
+func alg2method(alg string, key interface{}) (jwt.SigningMethod, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("Unsupported algorithm %s", alg)
+	}
+
+	var ok bool
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		switch key.(type) {
+		case []byte, string:
+			ok = true
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		switch key.(type) {
+		case rsa.PrivateKey, *rsa.PrivateKey, rsa.PublicKey, *rsa.PublicKey:
+			ok = true
+		}
+	case *jwt.SigningMethodECDSA:
+		// ES algorithms are bound to a specific curve
+		ok = key2method(key) == method
+	case *signingMethodEdDSA:
+		switch key.(type) {
+		case ed25519.PrivateKey, ed25519.PublicKey:
+			ok = true
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("Key type %T is incompatible with algorithm %s", key, alg)
+	}
+	return method, nil
+}
@@ -0,0 +1,179 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Introspector validates opaque tokens by asking an OAuth 2.0
+	// authorization server whether they are active, as described by RFC 7662.
+	// The server's response becomes the token's Claims; its space-separated
+	// "scope" member is also stored as a list under ScopesClaim, so that
+	// DefaultAuthorization works as it does for JWTs.
+	//
+	// Active responses are cached until the token expires (and no longer than
+	// MaxCacheAge, if set). Responses without "exp" are cached only if
+	// MaxCacheAge is set. Inactive responses are never cached. At most
+	// MaxCacheSize responses are cached; the least recently used are evicted
+	// first.
+	//
+	// To use an Introspector, pass it to AuthenticateWithOptions using the
+	// Introspection option. Introspector is safe for concurrent use.
+	Introspector struct {
+		// Endpoint is the URL of the introspection endpoint.
+		Endpoint string
+
+		// ClientID and ClientSecret authenticate this service to the
+		// introspection endpoint using HTTP Basic authentication. If
+		// ClientID is empty, requests are not authenticated.
+		ClientID     string
+		ClientSecret string
+
+		// Client sends requests to the introspection endpoint. If nil,
+		// http.DefaultClient is used.
+		Client *http.Client
+
+		// MaxCacheAge limits how long a response is cached. If zero,
+		// responses are cached until the token expires.
+		MaxCacheAge time.Duration
+
+		// MaxCacheSize limits how many responses are cached. If zero,
+		// DefaultIntrospectionCacheSize is used.
+		MaxCacheSize int
+
+		mu    sync.Mutex
+		cache *TokenCache
+	}
+)
+
+// DefaultIntrospectionCacheSize is the number of responses that an
+// Introspector caches, unless told otherwise.
+const DefaultIntrospectionCacheSize = 10000
+
+// maxIntrospectionResponseSize is the size of the largest introspection
+// response that an Introspector accepts.
+const maxIntrospectionResponseSize = 1 << 20
+
+// Introspect asks the introspection endpoint whether a token is active, and
+// returns its claims if so. It returns ErrInvalidToken if the token is not
+// active or has expired, and ErrUnavailable if the endpoint cannot be
+// consulted.
+func (in *Introspector) Introspect(ctx context.Context, token string) (Claims, error) {
+	now := time.Now()
+	cache := in.responses()
+	if cached, _, ok := cache.get(token); ok {
+		return cached, nil
+	}
+	generation := cache.snapshot()
+
+	claims, err := in.request(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := claims["exp"]; ok && !now.Before(claims.ExpiresAt()) {
+		return nil, ErrInvalidToken("Token is expired")
+	}
+	if _, ok := claims["nbf"]; ok && now.Before(claims.NotBefore()) {
+		return nil, ErrInvalidToken("Token is not valid yet")
+	}
+
+	var expires time.Time
+	if _, ok := claims["exp"]; ok {
+		expires = claims.ExpiresAt()
+	}
+	if in.MaxCacheAge > 0 && (expires.IsZero() || now.Add(in.MaxCacheAge).Before(expires)) {
+		expires = now.Add(in.MaxCacheAge)
+	}
+	if !expires.IsZero() {
+		cache.put(token, claims, "", expires, generation)
+	}
+
+	return copyClaims(claims), nil
+}
+
+// request calls the introspection endpoint and translates an active
+// response into claims.
+func (in *Introspector) request(ctx context.Context, token string) (Claims, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest("POST", in.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, ErrUnsupported("invalid introspection endpoint", "error", err.Error())
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if in.ClientID != "" {
+		// RFC 6749 section 2.3.1 requires the credentials to be form-encoded
+		req.SetBasicAuth(url.QueryEscape(in.ClientID), url.QueryEscape(in.ClientSecret))
+	}
+
+	client := in.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, ErrUnavailable("Cannot introspect token", "error", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrUnavailable("Cannot introspect token", "status", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIntrospectionResponseSize+1))
+	if err != nil {
+		return nil, ErrUnavailable("Cannot introspect token", "error", err.Error())
+	}
+	if len(body) > maxIntrospectionResponseSize {
+		return nil, ErrUnavailable("Cannot introspect token", "error", "response is too large")
+	}
+	var claims Claims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, ErrUnavailable("Cannot introspect token", "error", err.Error())
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, ErrInvalidToken("Inactive token")
+	}
+	delete(claims, "active")
+
+	if scope, ok := claims["scope"].(string); ok {
+		claims[ScopesClaim] = strings.Fields(scope)
+	}
+
+	return claims, nil
+}
+
+// responses returns the cache of introspection responses, creating it if
+// necessary.
+func (in *Introspector) responses() *TokenCache {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.cache == nil {
+		size := in.MaxCacheSize
+		if size == 0 {
+			size = DefaultIntrospectionCacheSize
+		}
+		in.cache = NewTokenCache(size)
+	}
+	return in.cache
+}
+
+// copyClaims makes a shallow copy of claims, so that callers cannot modify
+// cached claims.
+func copyClaims(claims Claims) Claims {
+	cp := make(Claims, len(claims))
+	for k, v := range claims {
+		cp[k] = v
+	}
+	return cp
+}
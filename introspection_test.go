@@ -0,0 +1,178 @@
+package jwtauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("Introspector", func() {
+	var server *httptest.Server
+	var calls int32
+	var responses map[string]map[string]interface{}
+	var introspector *jwtauth.Introspector
+
+	BeforeEach(func() {
+		calls = 0
+		exp := time.Now().Add(time.Hour).Unix()
+		responses = map[string]map[string]interface{}{
+			"good":    {"active": true, "sub": "alice", "scope": "read write", "exp": exp},
+			"forever": {"active": true, "sub": "bob"},
+			"revoked": {"active": false},
+			"stale":   {"active": true, "sub": "carol", "exp": time.Now().Add(-time.Minute).Unix()},
+			"huge":    {"active": true, "sub": strings.Repeat("x", 2<<20)},
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "my-service" || secret != "s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method != "POST" || r.PostFormValue("token_type_hint") != "access_token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			resp, ok := responses[r.PostFormValue("token")]
+			if !ok {
+				resp = map[string]interface{}{"active": false}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		}))
+
+		introspector = &jwtauth.Introspector{
+			Endpoint:     server.URL,
+			ClientID:     "my-service",
+			ClientSecret: "s3cret",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("translates active responses into claims", func() {
+		claims, err := introspector.Introspect(context.Background(), "good")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Subject()).Should(Equal("alice"))
+		Ω(claims.Strings(jwtauth.ScopesClaim)).Should(Equal([]string{"read", "write"}))
+		Ω(claims).ShouldNot(HaveKey("active"))
+	})
+
+	It("rejects inactive and expired tokens", func() {
+		_, err := introspector.Introspect(context.Background(), "revoked")
+		Ω(err).Should(HaveResponseStatus(401))
+		_, err = introspector.Introspect(context.Background(), "stale")
+		Ω(err).Should(HaveResponseStatus(401))
+	})
+
+	It("fails when the endpoint refuses to answer", func() {
+		introspector.ClientSecret = "wrong"
+		_, err := introspector.Introspect(context.Background(), "good")
+		Ω(err).Should(HaveResponseStatus(503))
+		Ω(err).Should(HaveMetaKey("status"))
+
+		introspector.Endpoint = "http://127.0.0.1:0/"
+		_, err = introspector.Introspect(context.Background(), "good")
+		Ω(err).Should(HaveResponseStatus(503))
+	})
+
+	It("rejects oversized responses", func() {
+		_, err := introspector.Introspect(context.Background(), "huge")
+		Ω(err).Should(HaveResponseStatus(503))
+		Ω(err).Should(HaveDetailSubstring("Cannot introspect token"))
+	})
+
+	It("caches active responses until the token expires", func() {
+		for i := 0; i < 3; i++ {
+			_, err := introspector.Introspect(context.Background(), "good")
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(1)))
+
+		for i := 0; i < 2; i++ {
+			introspector.Introspect(context.Background(), "revoked")
+			introspector.Introspect(context.Background(), "forever")
+		}
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(5)))
+	})
+
+	It("limits the age of cached responses", func() {
+		introspector.MaxCacheAge = 20 * time.Millisecond
+		introspector.Introspect(context.Background(), "forever")
+		introspector.Introspect(context.Background(), "forever")
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(1)))
+
+		time.Sleep(30 * time.Millisecond)
+		introspector.Introspect(context.Background(), "forever")
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(2)))
+	})
+
+	It("limits the number of cached responses", func() {
+		introspector.MaxCacheSize = 1
+		introspector.MaxCacheAge = time.Minute
+		introspector.Introspect(context.Background(), "good")
+		introspector.Introspect(context.Background(), "forever")
+		introspector.Introspect(context.Background(), "good")
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(3)))
+
+		introspector.Introspect(context.Background(), "good")
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(3)))
+	})
+
+	It("does not share cached claims", func() {
+		claims, _ := introspector.Introspect(context.Background(), "good")
+		claims["sub"] = "mallory"
+		claims, _ = introspector.Introspect(context.Background(), "good")
+		Ω(claims.Subject()).Should(Equal("alice"))
+	})
+
+	Context("with the authentication middleware", func() {
+		var stack goa.Handler
+		var resp *httptest.ResponseRecorder
+		var req *http.Request
+		var claims jwtauth.Claims
+
+		BeforeEach(func() {
+			resp = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "http://example.com/", nil)
+			stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				claims = jwtauth.ContextClaims(ctx)
+				return nil
+			}
+
+			authentication := jwtauth.AuthenticateWithOptions(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1},
+				jwtauth.Introspection(introspector))
+			stack = authentication(jwtauth.Authorize()(stack))
+		})
+
+		It("introspects opaque tokens", func() {
+			ctx := goa.WithRequiredScopes(context.Background(), []string{"read"})
+
+			setBearerHeader(req, "good")
+			Ω(stack(ctx, resp, req)).ShouldNot(HaveOccurred())
+			Ω(claims.Subject()).Should(Equal("alice"))
+
+			setBearerHeader(req, "revoked")
+			Ω(stack(ctx, resp, req)).Should(HaveResponseStatus(401))
+		})
+
+		It("verifies JWTs locally", func() {
+			setBearerHeader(req, makeToken("alice", "bob", hmacKey1))
+			Ω(stack(context.Background(), resp, req)).ShouldNot(HaveOccurred())
+			Ω(claims.Subject()).Should(Equal("bob"))
+			Ω(atomic.LoadInt32(&calls)).Should(BeZero())
+		})
+	})
+})
@@ -0,0 +1,582 @@
+package jwtauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// DecryptionKeystore is a concurrency-safe, in-memory store of the keys
+	// with which the authentication middleware decrypts encrypted tokens
+	// (JWE). Keys are identified by key ID, which is matched against the
+	// "kid" header of each token; if a token has no "kid", every key is
+	// tried. It accepts any of the following types:
+	//     - *rsa.PrivateKey (for "RSA-OAEP")
+	//     - *ecdsa.PrivateKey (for "ECDH-ES")
+	//     - []byte or string of 32 bytes (for "A256KW")
+	//
+	// Content may be encrypted with "A256GCM" or "A128CBC-HS256".
+	//
+	// To use a DecryptionKeystore, pass it to AuthenticateWithOptions using
+	// the Decryption option. All methods are safe to call on the zero value
+	// of this type.
+	DecryptionKeystore struct {
+		sync.RWMutex
+		keys map[string]interface{}
+	}
+)
+
+// Add makes a key available for decryption under a key ID.
+func (dk *DecryptionKeystore) Add(kid string, key interface{}) error {
+	if s, ok := key.(string); ok {
+		key = []byte(s)
+	}
+	switch kt := key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	case []byte:
+		if len(kt) != 32 {
+			return fmt.Errorf("A256KW keys must be 32 bytes long, not %d", len(kt))
+		}
+	default:
+		return fmt.Errorf("unsupported decryption key type %T", key)
+	}
+
+	dk.Lock()
+	defer dk.Unlock()
+
+	if dk.keys == nil {
+		dk.keys = map[string]interface{}{}
+	}
+	dk.keys[kid] = key
+	return nil
+}
+
+// Remove makes a key unavailable for decryption.
+func (dk *DecryptionKeystore) Remove(kid string) {
+	dk.Lock()
+	defer dk.Unlock()
+	delete(dk.keys, kid)
+}
+
+// Get returns the key with the given key ID, or nil if there is none.
+func (dk *DecryptionKeystore) Get(kid string) interface{} {
+	dk.RLock()
+	defer dk.RUnlock()
+	return dk.keys[kid]
+}
+
+// candidates returns the keys that might decrypt a token with the given
+// "kid" header.
+func (dk *DecryptionKeystore) candidates(kid string) []interface{} {
+	dk.RLock()
+	defer dk.RUnlock()
+
+	if kid != "" {
+		if key := dk.keys[kid]; key != nil {
+			return []interface{}{key}
+		}
+		return nil
+	}
+
+	kids := make([]string, 0, len(dk.keys))
+	for k := range dk.keys {
+		kids = append(kids, k)
+	}
+	sort.Strings(kids)
+	keys := make([]interface{}, len(kids))
+	for i, k := range kids {
+		keys[i] = dk.keys[k]
+	}
+	return keys
+}
+
+// decrypt decrypts a JWE in compact serialization and returns its payload.
+func (dk *DecryptionKeystore) decrypt(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, ErrInvalidToken("Malformed encrypted token")
+	}
+	var raw [5][]byte
+	for i, part := range parts {
+		var err error
+		if raw[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, ErrInvalidToken("Malformed encrypted token", "error", err.Error())
+		}
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(raw[0], &header); err != nil {
+		return nil, ErrInvalidToken("Malformed encrypted token", "error", err.Error())
+	}
+
+	alg, _ := header["alg"].(string)
+	enc, _ := header["enc"].(string)
+	kid, _ := header["kid"].(string)
+	if _, err := contentKeySize(enc); err != nil {
+		return nil, ErrInvalidToken("Unsupported encrypted token", "enc", enc)
+	}
+	switch alg {
+	case "RSA-OAEP", "ECDH-ES", "A256KW":
+	default:
+		return nil, ErrInvalidToken("Unsupported encrypted token", "alg", alg)
+	}
+	for _, name := range []string{"zip", "crit"} {
+		if _, ok := header[name]; ok {
+			return nil, ErrInvalidToken("Unsupported encrypted token", name, header[name])
+		}
+	}
+
+	keys := dk.candidates(kid)
+	if len(keys) == 0 {
+		return nil, ErrInvalidToken("Unknown decryption key", "kid", kid)
+	}
+	for _, key := range keys {
+		cek, err := unwrapContentKey(alg, enc, header, key, raw[1])
+		if err != nil {
+			continue
+		}
+		plaintext, err := decryptContent(enc, cek, raw[2], raw[3], raw[4], []byte(parts[0]))
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrInvalidToken("Cannot decrypt token", "kid", kid, "alg", alg, "enc", enc)
+}
+
+// encryptJWE encrypts a payload for a recipient's public key (or shared
+// secret) and returns a JWE in compact serialization. The header contains
+// "alg", "enc" and "cty", plus "kid" if it is not empty.
+func encryptJWE(payload []byte, key interface{}, alg, enc, kid string) (string, error) {
+	size, err := contentKeySize(enc)
+	if err != nil {
+		return "", err
+	}
+	header := map[string]interface{}{"alg": alg, "enc": enc, "cty": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+
+	var cek, encryptedKey []byte
+	switch alg {
+	case "RSA-OAEP":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("%s requires an RSA public key, not %T", alg, key)
+		}
+		if cek, err = randomBytes(size); err != nil {
+			return "", err
+		}
+		if encryptedKey, err = rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, cek, nil); err != nil {
+			return "", err
+		}
+	case "ECDH-ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("%s requires an ECDSA public key, not %T", alg, key)
+		}
+		eph, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		epk, err := json.Marshal(&JWK{Key: &eph.PublicKey})
+		if err != nil {
+			return "", err
+		}
+		header["epk"] = json.RawMessage(epk)
+		z, err := ecdhSharedSecret(eph, pub)
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(z, enc, nil, nil, size)
+	case "A256KW":
+		kek, ok := key.([]byte)
+		if !ok || len(kek) != 32 {
+			return "", fmt.Errorf("%s requires a 32-byte key", alg)
+		}
+		if cek, err = randomBytes(size); err != nil {
+			return "", err
+		}
+		if encryptedKey, err = aesKeyWrap(kek, cek); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported key management algorithm %q", alg)
+	}
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	aad := encodeJWKBytes(rawHeader)
+	iv, ciphertext, tag, err := encryptContent(enc, cek, payload, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		aad,
+		encodeJWKBytes(encryptedKey),
+		encodeJWKBytes(iv),
+		encodeJWKBytes(ciphertext),
+		encodeJWKBytes(tag),
+	}, "."), nil
+}
+
+// recipient normalizes the key of a token's recipient for use by encryptJWE,
+// and returns it with its key ID (if any).
+func recipient(key interface{}) (interface{}, string) {
+	var kid string
+	if jwk, ok := key.(*JWK); ok {
+		key, kid = jwk.Key, jwk.KeyID
+	}
+	switch kt := key.(type) {
+	case string:
+		return []byte(kt), kid
+	case rsa.PublicKey:
+		return &kt, kid
+	case *rsa.PrivateKey:
+		return &kt.PublicKey, kid
+	case ecdsa.PublicKey:
+		return &kt, kid
+	case *ecdsa.PrivateKey:
+		return &kt.PublicKey, kid
+	default:
+		return key, kid
+	}
+}
+
+// key2encryption determines the JWA key management algorithm that suits a
+// recipient key, or "" if the key is unsuitable.
+func key2encryption(key interface{}) string {
+	switch kt := key.(type) {
+	case *rsa.PublicKey:
+		return "RSA-OAEP"
+	case *ecdsa.PublicKey:
+		return "ECDH-ES"
+	case []byte:
+		if len(kt) == 32 {
+			return "A256KW"
+		}
+	}
+	return ""
+}
+
+// isJWE determines whether a token looks like a JWE in compact
+// serialization: five base64url segments, the first of which is a JSON
+// object with an "enc" member.
+func isJWE(tok string) bool {
+	bits := strings.Split(tok, ".")
+	if len(bits) != 5 {
+		return false
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(bits[0])
+	if err != nil {
+		return false
+	}
+	var header map[string]interface{}
+	if json.Unmarshal(rawHeader, &header) != nil {
+		return false
+	}
+	_, ok := header["enc"]
+	return ok
+}
+
+// unwrapContentKey recovers a JWE's content encryption key.
+func unwrapContentKey(alg, enc string, header map[string]interface{}, key interface{}, encryptedKey []byte) ([]byte, error) {
+	size, err := contentKeySize(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	var cek []byte
+	switch alg {
+	case "RSA-OAEP":
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA private key", alg)
+		}
+		if cek, err = rsa.DecryptOAEP(sha1.New(), nil, priv, encryptedKey, nil); err != nil {
+			return nil, err
+		}
+	case "ECDH-ES":
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an ECDSA private key", alg)
+		}
+		if len(encryptedKey) != 0 {
+			return nil, fmt.Errorf("%s requires an empty encrypted key", alg)
+		}
+		rawEPK, err := json.Marshal(header["epk"])
+		if err != nil {
+			return nil, err
+		}
+		epk, err := ParseJWK(rawEPK)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := epk.Key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("epk is not an EC public key")
+		}
+		z, err := ecdhSharedSecret(priv, pub)
+		if err != nil {
+			return nil, err
+		}
+		apu, err := headerBytes(header, "apu")
+		if err != nil {
+			return nil, err
+		}
+		apv, err := headerBytes(header, "apv")
+		if err != nil {
+			return nil, err
+		}
+		cek = concatKDF(z, enc, apu, apv, size)
+	case "A256KW":
+		kek, ok := key.([]byte)
+		if !ok || len(kek) != 32 {
+			return nil, fmt.Errorf("%s requires a 32-byte key", alg)
+		}
+		if cek, err = aesKeyUnwrap(kek, encryptedKey); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key management algorithm %q", alg)
+	}
+
+	if len(cek) != size {
+		return nil, errors.New("content encryption key has the wrong size")
+	}
+	return cek, nil
+}
+
+// contentKeySize returns the size of the content encryption key that a
+// content encryption algorithm requires.
+func contentKeySize(enc string) (int, error) {
+	switch enc {
+	case "A256GCM", "A128CBC-HS256":
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported content encryption algorithm %q", enc)
+	}
+}
+
+// encryptContent encrypts and authenticates a JWE's payload.
+func encryptContent(enc string, cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	switch enc {
+	case "A256GCM":
+		gcm, err := newGCM(cek)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if iv, err = randomBytes(gcm.NonceSize()); err != nil {
+			return nil, nil, nil, err
+		}
+		sealed := gcm.Seal(nil, iv, plaintext, aad)
+		split := len(sealed) - gcm.Overhead()
+		return iv, sealed[:split], sealed[split:], nil
+	case "A128CBC-HS256":
+		macKey, encKey := cek[:16], cek[16:]
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if iv, err = randomBytes(aes.BlockSize); err != nil {
+			return nil, nil, nil, err
+		}
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		ciphertext = append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+		return iv, ciphertext, cbcHMAC(macKey, aad, iv, ciphertext), nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported content encryption algorithm %q", enc)
+	}
+}
+
+// decryptContent authenticates and decrypts a JWE's payload.
+func decryptContent(enc string, cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	switch enc {
+	case "A256GCM":
+		gcm, err := newGCM(cek)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != gcm.NonceSize() {
+			return nil, errors.New("invalid IV")
+		}
+		return gcm.Open(nil, iv, append(append([]byte{}, ciphertext...), tag...), aad)
+	case "A128CBC-HS256":
+		macKey, encKey := cek[:16], cek[16:]
+		// check the MAC before decrypting, so that we are not a padding oracle
+		if !hmac.Equal(tag, cbcHMAC(macKey, aad, iv, ciphertext)) {
+			return nil, errors.New("authentication tag mismatch")
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, errors.New("invalid ciphertext")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+			return nil, errors.New("invalid padding")
+		}
+		return plaintext[:len(plaintext)-padding], nil
+	default:
+		return nil, fmt.Errorf("unsupported content encryption algorithm %q", enc)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cbcHMAC computes the authentication tag of AES_CBC_HMAC_SHA2 (RFC 7518
+// section 5.2.2.1).
+func cbcHMAC(macKey, aad, iv, ciphertext []byte) []byte {
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al)
+	return mac.Sum(nil)[:16]
+}
+
+// ecdhSharedSecret computes the ECDH shared secret Z: the x-coordinate of
+// the product of priv and pub.
+func ecdhSharedSecret(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
+	params := priv.Curve.Params()
+	if pub.Curve.Params().Name != params.Name {
+		return nil, fmt.Errorf("epk uses curve %s, but the key uses %s", pub.Curve.Params().Name, params.Name)
+	}
+	if !priv.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("epk is not on the curve")
+	}
+	x, _ := priv.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+	return padJWKBytes(x.Bytes(), (params.BitSize+7)/8), nil
+}
+
+// concatKDF derives a key from an ECDH shared secret using the Concat KDF
+// of NIST SP 800-56A, as profiled by RFC 7518 section 4.6.2.
+func concatKDF(z []byte, algID string, apu, apv []byte, size int) []byte {
+	var otherInfo []byte
+	for _, field := range [][]byte{[]byte(algID), apu, apv} {
+		otherInfo = appendUint32(otherInfo, uint32(len(field)))
+		otherInfo = append(otherInfo, field...)
+	}
+	otherInfo = appendUint32(otherInfo, uint32(size*8))
+
+	var key []byte
+	for counter := uint32(1); len(key) < size; counter++ {
+		h := sha256.New()
+		h.Write(appendUint32(nil, counter))
+		h.Write(z)
+		h.Write(otherInfo)
+		key = h.Sum(key)
+	}
+	return key[:size]
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// aesKeyWrapIV is the default initial value of RFC 3394.
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// aesKeyWrap wraps a key using the AES Key Wrap algorithm of RFC 3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("key to wrap must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	a := append([]byte{}, aesKeyWrapIV...)
+	r := append([]byte{}, key...)
+	buf := make([]byte, 16)
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^uint64(n*j+i))
+			copy(r[(i-1)*8:], buf[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// aesKeyUnwrap unwraps a key that was wrapped by aesKeyWrap.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("wrapped key must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := append([]byte{}, wrapped[:8]...)
+	r := append([]byte{}, wrapped[8:]...)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^uint64(n*j+i))
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[(i-1)*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, aesKeyWrapIV) != 1 {
+		return nil, errors.New("key unwrap failed integrity check")
+	}
+	return r, nil
+}
+
+// headerBytes decodes an optional base64url-encoded header parameter.
+func headerBytes(header map[string]interface{}, name string) ([]byte, error) {
+	value, _ := header[name].(string)
+	if value == "" {
+		return nil, nil
+	}
+	return decodeJWKBytes(name, value)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,210 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DecryptionKeystore", func() {
+	var rsaKey *rsa.PrivateKey
+	var ecKey *ecdsa.PrivateKey
+	var kwKey []byte
+	var keys *DecryptionKeystore
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Ω(err).ShouldNot(HaveOccurred())
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).ShouldNot(HaveOccurred())
+		kwKey = []byte("0123456789abcdef0123456789abcdef")
+
+		keys = &DecryptionKeystore{}
+		Ω(keys.Add("rsa", rsaKey)).Should(Succeed())
+		Ω(keys.Add("ec", ecKey)).Should(Succeed())
+		Ω(keys.Add("kw", kwKey)).Should(Succeed())
+	})
+
+	It("rejects unsupported keys", func() {
+		Ω(keys.Add("short", []byte("short"))).ShouldNot(Succeed())
+		Ω(keys.Add("public", &rsaKey.PublicKey)).ShouldNot(Succeed())
+		Ω(keys.Get("short")).Should(BeNil())
+	})
+
+	It("removes keys", func() {
+		keys.Remove("kw")
+		Ω(keys.Get("kw")).Should(BeNil())
+		Ω(keys.Get("rsa")).Should(Equal(rsaKey))
+	})
+
+	for _, alg := range []string{"RSA-OAEP", "ECDH-ES", "A256KW"} {
+		for _, enc := range []string{"A256GCM", "A128CBC-HS256"} {
+			alg, enc := alg, enc
+			It("decrypts "+alg+" with "+enc, func() {
+				var key interface{}
+				var kid string
+				switch alg {
+				case "RSA-OAEP":
+					key, kid = &rsaKey.PublicKey, "rsa"
+				case "ECDH-ES":
+					key, kid = &ecKey.PublicKey, "ec"
+				case "A256KW":
+					key, kid = kwKey, "kw"
+				}
+				for _, payload := range []string{"hello", "exactly 16 bytes"} {
+					tok, err := encryptJWE([]byte(payload), key, alg, enc, kid)
+					Ω(err).ShouldNot(HaveOccurred())
+					Ω(isJWE(tok)).Should(BeTrue())
+
+					plaintext, err := keys.decrypt(tok)
+					Ω(err).ShouldNot(HaveOccurred())
+					Ω(string(plaintext)).Should(Equal(payload))
+				}
+			})
+		}
+	}
+
+	It("tries every key when there is no kid", func() {
+		tok, err := encryptJWE([]byte("hello"), kwKey, "A256KW", "A256GCM", "")
+		Ω(err).ShouldNot(HaveOccurred())
+		plaintext, err := keys.decrypt(tok)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(plaintext)).Should(Equal("hello"))
+	})
+
+	It("rejects unknown key IDs", func() {
+		tok, err := encryptJWE([]byte("hello"), kwKey, "A256KW", "A256GCM", "other")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = keys.decrypt(tok)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("Unknown decryption key"))
+	})
+
+	It("rejects the wrong key", func() {
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).ShouldNot(HaveOccurred())
+		tok, err := encryptJWE([]byte("hello"), &other.PublicKey, "ECDH-ES", "A256GCM", "ec")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = keys.decrypt(tok)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("Cannot decrypt token"))
+	})
+
+	It("rejects tampered tokens", func() {
+		for _, enc := range []string{"A256GCM", "A128CBC-HS256"} {
+			tok, err := encryptJWE([]byte("hello"), kwKey, "A256KW", enc, "kw")
+			Ω(err).ShouldNot(HaveOccurred())
+			for i := range strings.Split(tok, ".") {
+				parts := strings.Split(tok, ".")
+				if parts[i] == "" {
+					continue
+				}
+				raw := []byte(parts[i])
+				raw[len(raw)/2] ^= 'A' ^ 'B'
+				parts[i] = string(raw)
+				_, err = keys.decrypt(strings.Join(parts, "."))
+				Ω(err).Should(HaveOccurred(), "tampered segment %d of %s", i, enc)
+			}
+		}
+	})
+
+	It("rejects compressed tokens", func() {
+		header := encodeJWKBytes([]byte(`{"alg":"A256KW","enc":"A256GCM","zip":"DEF"}`))
+		_, err := keys.decrypt(header + ".AA.AA.AA.AA")
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("Unsupported encrypted token"))
+	})
+})
+
+var _ = Describe("aesKeyWrap()", func() {
+	// RFC 3394 section 4.6: wrap 256 bits of key data with a 256-bit KEK
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	data, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	wrapped, _ := hex.DecodeString("28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+
+	It("matches the test vector", func() {
+		result, err := aesKeyWrap(kek, data)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(result).Should(Equal(wrapped))
+
+		result, err = aesKeyUnwrap(kek, wrapped)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(result).Should(Equal(data))
+	})
+
+	It("detects corruption", func() {
+		corrupt := append([]byte{}, wrapped...)
+		corrupt[10] ^= 1
+		_, err := aesKeyUnwrap(kek, corrupt)
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("Decryption()", func() {
+	var o *options
+	var store *NamedKeystore
+	var kwKey []byte
+	var signed string
+
+	BeforeEach(func() {
+		kwKey = []byte("0123456789abcdef0123456789abcdef")
+		keys := &DecryptionKeystore{}
+		Ω(keys.Add("kw", kwKey)).Should(Succeed())
+		o = &options{}
+		Decryption(keys)(o)
+
+		store = &NamedKeystore{}
+		Ω(store.Trust("alice", []byte("signing secret"))).Should(Succeed())
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss": "alice",
+			"sub": "bob",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		var err error
+		signed, err = token.SignedString([]byte("signing secret"))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("verifies nested tokens", func() {
+		tok, err := encryptJWE([]byte(signed), kwKey, "A256KW", "A128CBC-HS256", "kw")
+		Ω(err).ShouldNot(HaveOccurred())
+		claims, _, err := o.authenticate(context.Background(), store, tok)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Issuer()).Should(Equal("alice"))
+		Ω(claims.Subject()).Should(Equal("bob"))
+	})
+
+	It("still accepts signed tokens", func() {
+		claims, _, err := o.authenticate(context.Background(), store, signed)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(claims.Subject()).Should(Equal("bob"))
+	})
+
+	It("rejects encrypted payloads that are not signed JWTs", func() {
+		tok, err := encryptJWE([]byte(`{"iss":"alice","sub":"bob"}`), kwKey, "A256KW", "A256GCM", "kw")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, _, err = o.authenticate(context.Background(), store, tok)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.(*goa.ErrorResponse).Status).Should(Equal(401))
+	})
+
+	It("verifies the signature of nested tokens", func() {
+		store.RevokeTrust("alice")
+		Ω(store.Trust("alice", []byte("other secret"))).Should(Succeed())
+		tok, err := encryptJWE([]byte(signed), kwKey, "A256KW", "A256GCM", "kw")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, _, err = o.authenticate(context.Background(), store, tok)
+		Ω(err).Should(HaveOccurred())
+	})
+})
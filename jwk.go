@@ -0,0 +1,383 @@
+package jwtauth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+type (
+	// JWK is a JSON Web Key (RFC 7517): a properly-typed key together with
+	// the metadata that accompanies it in a JWK document.
+	//
+	// Key is one of the following types:
+	//     - []byte ("oct" keys)
+	//     - *rsa.PublicKey or *rsa.PrivateKey ("RSA" keys)
+	//     - *ecdsa.PublicKey or *ecdsa.PrivateKey ("EC" keys)
+	//     - ed25519.PublicKey or ed25519.PrivateKey ("OKP" keys)
+	//
+	// JWK implements json.Marshaler and json.Unmarshaler. When marshaled, a
+	// JWK never includes private key material: private keys are represented
+	// by their public half. Because "oct" keys have no public half, they are
+	// marshaled as-is; take care not to publish them!
+	JWK struct {
+		Key       interface{}
+		KeyID     string
+		Algorithm string
+		Use       string
+	}
+
+	// JWKSet is a JSON Web Key Set (RFC 7517 Section 5).
+	//
+	// When unmarshaling, keys with an unsupported "kty" are skipped as
+	// recommended by the RFC.
+	JWKSet struct {
+		Keys []*JWK `json:"keys"`
+	}
+
+	// jsonWebKey is the wire format of a JWK.
+	jsonWebKey struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid,omitempty"`
+		Alg string `json:"alg,omitempty"`
+		Use string `json:"use,omitempty"`
+		Crv string `json:"crv,omitempty"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+		D   string `json:"d,omitempty"`
+		P   string `json:"p,omitempty"`
+		Q   string `json:"q,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+		K   string `json:"k,omitempty"`
+	}
+)
+
+var jwkDocument = regexp.MustCompile(`^[ \t\r\n]*\{`)
+
+var errUnsupportedKty = errors.New("unsupported key type")
+
+// ParseJWK parses a single JSON Web Key.
+func ParseJWK(data []byte) (*JWK, error) {
+	jwk := &JWK{}
+	if err := json.Unmarshal(data, jwk); err != nil {
+		return nil, err
+	}
+	return jwk, nil
+}
+
+// ParseJWKSet parses a JSON Web Key Set.
+func ParseJWKSet(data []byte) (*JWKSet, error) {
+	set := &JWKSet{}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Parse every key from a JSON document that contains either a JWK or a JWKS.
+func parseJWKs(data []byte) ([]interface{}, error) {
+	var probe struct {
+		Keys json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.Keys == nil {
+		jwk, err := ParseJWK(data)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{jwk.Key}, nil
+	}
+
+	set, err := ParseJWKSet(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("JWK set contains no supported keys")
+	}
+	keys := make([]interface{}, len(set.Keys))
+	for i, jwk := range set.Keys {
+		keys[i] = jwk.Key
+	}
+	return keys, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (k *JWK) UnmarshalJSON(data []byte) error {
+	var raw jsonWebKey
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	key, err := raw.key()
+	if err != nil {
+		if raw.Kid != "" {
+			return fmt.Errorf("JWK %q (%s): %s", raw.Kid, raw.Kty, err)
+		}
+		return fmt.Errorf("JWK (%s): %s", raw.Kty, err)
+	}
+
+	*k = JWK{Key: key, KeyID: raw.Kid, Algorithm: raw.Alg, Use: raw.Use}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (k JWK) MarshalJSON() ([]byte, error) {
+	raw, err := k.wire()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// wire converts a JWK into its wire format.
+func (k *JWK) wire() (*jsonWebKey, error) {
+	raw := &jsonWebKey{Kid: k.KeyID, Alg: k.Algorithm, Use: k.Use}
+
+	key := k.Key
+	if pk, ok := key.(privateKey); ok {
+		key = pk.Public()
+	}
+
+	switch kt := key.(type) {
+	case []byte:
+		raw.Kty = "oct"
+		raw.K = encodeJWKBytes(kt)
+	case string:
+		raw.Kty = "oct"
+		raw.K = encodeJWKBytes([]byte(kt))
+	case *rsa.PublicKey:
+		raw.Kty = "RSA"
+		raw.N = encodeJWKBytes(kt.N.Bytes())
+		raw.E = encodeJWKBytes(big.NewInt(int64(kt.E)).Bytes())
+	case *ecdsa.PublicKey:
+		params := kt.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		raw.Kty = "EC"
+		raw.Crv = params.Name
+		raw.X = encodeJWKBytes(padJWKBytes(kt.X.Bytes(), size))
+		raw.Y = encodeJWKBytes(padJWKBytes(kt.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		raw.Kty = "OKP"
+		raw.Crv = "Ed25519"
+		raw.X = encodeJWKBytes(kt)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k.Key)
+	}
+
+	return raw, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *JWKSet) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	keys := make([]*JWK, 0, len(raw.Keys))
+	for _, rk := range raw.Keys {
+		jwk := &JWK{}
+		if err := json.Unmarshal(rk, jwk); err != nil {
+			var probe jsonWebKey
+			if json.Unmarshal(rk, &probe) == nil && !isSupportedKty(probe.Kty) {
+				continue
+			}
+			return err
+		}
+		keys = append(keys, jwk)
+	}
+
+	s.Keys = keys
+	return nil
+}
+
+// Key returns the key with the specified ID, or nil if there is none.
+func (s *JWKSet) Key(kid string) *JWK {
+	for _, k := range s.Keys {
+		if k.KeyID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+func isSupportedKty(kty string) bool {
+	switch kty {
+	case "oct", "RSA", "EC", "OKP":
+		return true
+	default:
+		return false
+	}
+}
+
+// key converts the wire format of a JWK into a properly-typed key.
+func (raw *jsonWebKey) key() (interface{}, error) {
+	switch raw.Kty {
+	case "oct":
+		return decodeJWKBytes("k", raw.K)
+	case "RSA":
+		return raw.rsaKey()
+	case "EC":
+		return raw.ecKey()
+	case "OKP":
+		return raw.okpKey()
+	default:
+		return nil, errUnsupportedKty
+	}
+}
+
+func (raw *jsonWebKey) rsaKey() (interface{}, error) {
+	n, err := decodeJWKInt("n", raw.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeJWKInt("e", raw.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("public exponent is too large")
+	}
+	pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+	if raw.D == "" {
+		return pub, nil
+	}
+
+	d, err := decodeJWKInt("d", raw.D)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJWKInt("p", raw.P)
+	if err != nil {
+		return nil, err
+	}
+	q, err := decodeJWKInt("q", raw.Q)
+	if err != nil {
+		return nil, err
+	}
+	priv := &rsa.PrivateKey{PublicKey: *pub, D: d, Primes: []*big.Int{p, q}}
+	if err := priv.Validate(); err != nil {
+		return nil, err
+	}
+	priv.Precompute()
+	return priv, nil
+}
+
+func (raw *jsonWebKey) ecKey() (interface{}, error) {
+	var curve elliptic.Curve
+	switch raw.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+	}
+
+	x, err := decodeJWKInt("x", raw.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeJWKInt("y", raw.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point is not on curve %s", raw.Crv)
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if raw.D == "" {
+		return pub, nil
+	}
+
+	d, err := decodeJWKInt("d", raw.D)
+	if err != nil {
+		return nil, err
+	}
+	priv := &ecdsa.PrivateKey{PublicKey: *pub, D: d}
+	if cx, cy := curve.ScalarBaseMult(d.Bytes()); cx.Cmp(x) != 0 || cy.Cmp(y) != 0 {
+		return nil, fmt.Errorf("private key does not match public key")
+	}
+	return priv, nil
+}
+
+func (raw *jsonWebKey) okpKey() (interface{}, error) {
+	if raw.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+	}
+
+	x, err := decodeJWKBytes("x", raw.X)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("wrong size for parameter \"x\"")
+	}
+	pub := ed25519.PublicKey(x)
+	if raw.D == "" {
+		return pub, nil
+	}
+
+	d, err := decodeJWKBytes("d", raw.D)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) != ed25519.SeedSize {
+		return nil, fmt.Errorf("wrong size for parameter \"d\"")
+	}
+	priv := ed25519.NewKeyFromSeed(d)
+	if !bytes.Equal(pub, priv.Public().(ed25519.PublicKey)) {
+		return nil, fmt.Errorf("private key does not match public key")
+	}
+	return priv, nil
+}
+
+func encodeJWKBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padJWKBytes left-pads b with zeroes; EC coordinates must have a fixed size.
+func padJWKBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+func decodeJWKBytes(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing parameter %q", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed parameter %q: %s", name, err)
+	}
+	return b, nil
+}
+
+func decodeJWKInt(name, value string) (*big.Int, error) {
+	b, err := decodeJWKBytes(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
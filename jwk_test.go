@@ -0,0 +1,170 @@
+package jwtauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// privateJWK renders a private key as a JWK document, which jwtauth itself
+// refuses to do.
+func privateJWK(key interface{}) []byte {
+	var doc map[string]interface{}
+	switch kt := key.(type) {
+	case *rsa.PrivateKey:
+		doc = map[string]interface{}{
+			"kty": "RSA",
+			"n":   b64(kt.N.Bytes()),
+			"e":   "AQAB",
+			"d":   b64(kt.D.Bytes()),
+			"p":   b64(kt.Primes[0].Bytes()),
+			"q":   b64(kt.Primes[1].Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		doc = map[string]interface{}{
+			"kty": "EC",
+			"crv": kt.Curve.Params().Name,
+			"x":   b64(kt.X.Bytes()),
+			"y":   b64(kt.Y.Bytes()),
+			"d":   b64(kt.D.Bytes()),
+		}
+	case ed25519.PrivateKey:
+		doc = map[string]interface{}{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(kt.Public().(ed25519.PublicKey)),
+			"d":   b64(kt.Seed()),
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported key type for tests: %T", key))
+	}
+	data, _ := json.Marshal(doc)
+	return data
+}
+
+var _ = Describe("JWK", func() {
+	It("parses metadata", func() {
+		jwk, err := jwtauth.ParseJWK([]byte(`{"kty":"oct","k":"SSBsaWtlIHRhY29z","kid":"k1","alg":"HS256","use":"sig"}`))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(jwk.Key).Should(Equal(hmacKey1))
+		Ω(jwk.KeyID).Should(Equal("k1"))
+		Ω(jwk.Algorithm).Should(Equal("HS256"))
+		Ω(jwk.Use).Should(Equal("sig"))
+	})
+
+	for name, key := range map[string]interface{}{"RSA": rsaKey1, "EC": ecKey1, "EC P-521": ecKey521, "OKP": edKey1} {
+		name, key := name, key
+
+		It("round-trips "+name+" public keys", func() {
+			data, err := json.Marshal(&jwtauth.JWK{Key: publicKey(key), KeyID: "k1"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			jwk, err := jwtauth.ParseJWK(data)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(jwk.Key).Should(Equal(publicKey(key)))
+			Ω(jwk.KeyID).Should(Equal("k1"))
+		})
+
+		It("parses "+name+" private keys", func() {
+			jwk, err := jwtauth.ParseJWK(privateJWK(key))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(jwk.Key).Should(BeAssignableToTypeOf(key))
+			Ω(publicKey(jwk.Key)).Should(Equal(publicKey(key)))
+		})
+
+		It("never marshals "+name+" private key material", func() {
+			data, err := json.Marshal(&jwtauth.JWK{Key: key})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(data)).ShouldNot(ContainSubstring(`"d"`))
+
+			jwk, err := jwtauth.ParseJWK(data)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(jwk.Key).Should(Equal(publicKey(key)))
+		})
+
+		It("never marshals "+name+" private key material from JWK values", func() {
+			data, err := json.Marshal(jwtauth.JWK{Key: key})
+			Ω(err).ShouldNot(HaveOccurred())
+			jwk, err := jwtauth.ParseJWK(data)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(jwk.Key).Should(Equal(publicKey(key)))
+
+			data, err = json.Marshal([]jwtauth.JWK{{Key: key, KeyID: "k1"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			set, err := jwtauth.ParseJWKSet([]byte(`{"keys":` + string(data) + `}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(set.Keys[0].Key).Should(Equal(publicKey(key)))
+			Ω(set.Keys[0].KeyID).Should(Equal("k1"))
+		})
+	}
+
+	It("rejects malformed keys", func() {
+		_, err := jwtauth.ParseJWK([]byte(`{"kty":"RSA","kid":"k1","e":"AQAB"}`))
+		Ω(err).Should(MatchError(`JWK "k1" (RSA): missing parameter "n"`))
+
+		_, err = jwtauth.ParseJWK([]byte(`{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`))
+		Ω(err).Should(MatchError(ContainSubstring("not on curve")))
+
+		_, err = jwtauth.ParseJWK([]byte(`{"kty":"OKP","crv":"X25519","x":"AQ"}`))
+		Ω(err).Should(MatchError(ContainSubstring("unsupported curve")))
+
+		_, err = jwtauth.ParseJWK([]byte(`{"kty":"cheese"}`))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("refuses to marshal unknown types", func() {
+		_, err := json.Marshal(&jwtauth.JWK{Key: 42})
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("JWKSet", func() {
+	var doc []byte
+
+	BeforeEach(func() {
+		set := &jwtauth.JWKSet{Keys: []*jwtauth.JWK{
+			{Key: rsaKey1, KeyID: "rsa", Use: "sig", Algorithm: "RS256"},
+			{Key: ecKey1, KeyID: "ec", Use: "sig", Algorithm: "ES256"},
+		}}
+		var err error
+		doc, err = json.Marshal(set)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("round-trips", func() {
+		set, err := jwtauth.ParseJWKSet(doc)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(set.Keys).Should(HaveLen(2))
+		Ω(set.Key("rsa").Key).Should(Equal(&rsaKey1.PublicKey))
+		Ω(set.Key("ec").Key).Should(Equal(&ecKey1.PublicKey))
+		Ω(set.Key("ec").Algorithm).Should(Equal("ES256"))
+		Ω(set.Key("missing")).Should(BeNil())
+	})
+
+	It("skips keys of unknown type", func() {
+		set, err := jwtauth.ParseJWKSet([]byte(`{"keys":[{"kty":"PQC","kid":"future"},{"kty":"oct","k":"SSBsaWtlIHRhY29z"}]}`))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(set.Keys).Should(HaveLen(1))
+	})
+
+	It("rejects malformed keys of known type", func() {
+		_, err := jwtauth.ParseJWKSet([]byte(`{"keys":[{"kty":"oct"}]}`))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("is understood by LoadKey and LoadKeys", func() {
+		Ω(jwtauth.LoadKey(doc)).Should(Equal(&rsaKey1.PublicKey))
+		Ω(jwtauth.LoadKeys(doc)).Should(Equal([]interface{}{&rsaKey1.PublicKey, &ecKey1.PublicKey}))
+	})
+})
@@ -0,0 +1,241 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// SigningKeySet holds the keys with which a service signs tokens, and
+	// publishes their public halves as a JWK Set so that recipients can verify
+	// the tokens. It is an http.Handler; mount it at JWKSPath:
+	//
+	//     keys, err := jwtauth.NewSigningKeySet("2024-01", myKey)
+	//     http.Handle(jwtauth.JWKSPath, keys)
+	//     tok, err := keys.NewToken(claims)
+	//
+	// Every key has a key ID, which SigningKeySet puts in the "kid" header of
+	// the tokens that it signs, so that recipients know which published key
+	// to verify them with.
+	//
+	// To rotate keys without disrupting recipients, first Announce the next
+	// key; it is published immediately, but not used. Once recipients have
+	// had time to fetch it (at least MaxAge), call Rotate to start signing
+	// with it. The retired key stays published for GracePeriod, so that
+	// tokens signed with it can still be verified until they expire.
+	//
+	// SigningKeySet is safe for concurrent use, but its exported fields must
+	// not be changed once it is in use.
+	SigningKeySet struct {
+		// MaxAge is how long recipients may cache the published key set. If
+		// zero, DefaultJWKSMaxAge is used.
+		MaxAge time.Duration
+
+		// GracePeriod is how long a retired key stays published; it should
+		// be at least the lifetime of the tokens that the key signed. If
+		// zero, DefaultJWKSGracePeriod is used.
+		GracePeriod time.Duration
+
+		mu      sync.RWMutex
+		current *JWK
+		next    *JWK
+		retired []retiredKey
+	}
+
+	// retiredKey is a key that is no longer used for signing, but is
+	// published until a certain time.
+	retiredKey struct {
+		jwk   *JWK
+		until time.Time
+	}
+)
+
+const (
+	// JWKSPath is the conventional path of a published JWK Set.
+	JWKSPath = "/.well-known/jwks.json"
+
+	// DefaultJWKSMaxAge is how long recipients may cache a published key
+	// set, unless told otherwise.
+	DefaultJWKSMaxAge = time.Hour
+
+	// DefaultJWKSGracePeriod is how long a retired key stays published,
+	// unless told otherwise.
+	DefaultJWKSGracePeriod = 24 * time.Hour
+)
+
+// NewSigningKeySet creates a SigningKeySet that signs tokens with key, and
+// identifies it with kid. The key must be a private key; HMAC keys cannot be
+// published.
+func NewSigningKeySet(kid string, key interface{}) (*SigningKeySet, error) {
+	jwk, err := signingJWK(kid, key)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKeySet{current: jwk}, nil
+}
+
+// Announce publishes the key that Rotate will start signing with, replacing
+// any key that was previously announced.
+func (ks *SigningKeySet) Announce(kid string, key interface{}) error {
+	jwk, err := signingJWK(kid, key)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.current.KeyID == kid || ks.isRetired(kid) {
+		return fmt.Errorf("key ID '%s' is already in use", kid)
+	}
+	ks.next = jwk
+	return nil
+}
+
+// Rotate starts signing with the announced key, and retires the key that
+// was used until now. It returns an error if no key has been announced.
+func (ks *SigningKeySet) Rotate() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.next == nil {
+		return fmt.Errorf("no key has been announced")
+	}
+	ks.retired = append(ks.pruned(time.Now()), retiredKey{
+		jwk:   ks.current,
+		until: time.Now().Add(ks.gracePeriod()),
+	})
+	ks.current, ks.next = ks.next, nil
+	return nil
+}
+
+// KeyID returns the ID of the key that signs tokens.
+func (ks *SigningKeySet) KeyID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.current.KeyID
+}
+
+// NewToken creates a JWT with the specified claims and signs it with the
+// current key, as NewToken does. The token's "kid" header identifies the
+// key.
+func (ks *SigningKeySet) NewToken(claims Claims) (string, error) {
+	ks.mu.RLock()
+	jwk := ks.current
+	ks.mu.RUnlock()
+
+	method, err := alg2method(jwk.Algorithm, jwk.Key)
+	if err != nil {
+		return "", err
+	}
+	return signToken(method, jwk.Key, claims, map[string]interface{}{"kid": jwk.KeyID})
+}
+
+// JWKSet returns the public halves of the current key, the announced key
+// (if any), and the keys that were retired less than GracePeriod ago.
+func (ks *SigningKeySet) JWKSet() *JWKSet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := &JWKSet{Keys: []*JWK{publicJWK(ks.current)}}
+	if ks.next != nil {
+		set.Keys = append(set.Keys, publicJWK(ks.next))
+	}
+	for _, r := range ks.pruned(time.Now()) {
+		set.Keys = append(set.Keys, publicJWK(r.jwk))
+	}
+	return set
+}
+
+// ServeHTTP implements http.Handler#ServeHTTP
+func (ks *SigningKeySet) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := json.Marshal(ks.JWKSet())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+
+	maxAge := ks.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultJWKSMaxAge
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+// pruned returns the retired keys that are still published at the specified
+// time. The caller must hold ks.mu.
+func (ks *SigningKeySet) pruned(now time.Time) []retiredKey {
+	var keys []retiredKey
+	for _, r := range ks.retired {
+		if now.Before(r.until) {
+			keys = append(keys, r)
+		}
+	}
+	return keys
+}
+
+// isRetired determines whether a key ID belongs to a published retired key.
+// The caller must hold ks.mu.
+func (ks *SigningKeySet) isRetired(kid string) bool {
+	for _, r := range ks.pruned(time.Now()) {
+		if r.jwk.KeyID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+func (ks *SigningKeySet) gracePeriod() time.Duration {
+	if ks.GracePeriod == 0 {
+		return DefaultJWKSGracePeriod
+	}
+	return ks.GracePeriod
+}
+
+// signingJWK describes a private key that signs tokens.
+func signingJWK(kid string, key interface{}) (*JWK, error) {
+	if kid == "" {
+		return nil, fmt.Errorf("signing keys must have a key ID")
+	}
+	if _, ok := key.(privateKey); !ok {
+		return nil, fmt.Errorf("signing keys must be private keys, not %T", key)
+	}
+	method := key2method(key)
+	if method == nil {
+		return nil, fmt.Errorf("Unsupported key type %T", key)
+	}
+	return &JWK{Key: key, KeyID: kid, Algorithm: method.Alg(), Use: "sig"}, nil
+}
+
+// publicJWK returns the public half of a signing key.
+func publicJWK(jwk *JWK) *JWK {
+	return &JWK{
+		Key:       jwk.Key.(privateKey).Public(),
+		KeyID:     jwk.KeyID,
+		Algorithm: jwk.Algorithm,
+		Use:       jwk.Use,
+	}
+}
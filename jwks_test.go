@@ -0,0 +1,136 @@
+package jwtauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("SigningKeySet", func() {
+	var keys *jwtauth.SigningKeySet
+
+	// fetch requests the published key set.
+	fetch := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", jwtauth.JWKSPath, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		keys.ServeHTTP(w, req)
+		return w
+	}
+
+	// published returns the IDs of the published keys.
+	published := func() []string {
+		w := fetch()
+		Ω(w.Code).Should(Equal(200))
+		set, err := jwtauth.ParseJWKSet(w.Body.Bytes())
+		Ω(err).ShouldNot(HaveOccurred())
+		var kids []string
+		for _, k := range set.Keys {
+			kids = append(kids, k.KeyID)
+		}
+		return kids
+	}
+
+	// verify verifies a token against the published key set.
+	verify := func(token string) error {
+		set, err := jwtauth.ParseJWKSet(fetch().Body.Bytes())
+		Ω(err).ShouldNot(HaveOccurred())
+		store := &jwtauth.NamedKeystore{}
+		Ω(store.Trust("me", set)).Should(Succeed())
+		stack := jwtauth.Authenticate(commonScheme, store)(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return nil
+			})
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		setBearerHeader(req, token)
+		return stack(context.Background(), httptest.NewRecorder(), req)
+	}
+
+	BeforeEach(func() {
+		var err error
+		keys, err = jwtauth.NewSigningKeySet("one", rsaKey1)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("publishes public keys", func() {
+		w := fetch()
+		Ω(w.Code).Should(Equal(200))
+		Ω(w.Header().Get("Content-Type")).Should(Equal("application/jwk-set+json"))
+		Ω(w.Header().Get("Cache-Control")).Should(Equal("public, max-age=3600"))
+		Ω(w.Body.String()).ShouldNot(ContainSubstring(`"d"`))
+
+		set, err := jwtauth.ParseJWKSet(w.Body.Bytes())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(set.Keys).Should(HaveLen(1))
+		Ω(set.Keys[0].KeyID).Should(Equal("one"))
+		Ω(set.Keys[0].Algorithm).Should(Equal("RS256"))
+		Ω(set.Keys[0].Use).Should(Equal("sig"))
+		Ω(set.Keys[0].Key).Should(Equal(rsaKey1.Public()))
+	})
+
+	It("supports conditional requests", func() {
+		etag := fetch().Header().Get("ETag")
+		Ω(etag).ShouldNot(BeEmpty())
+		Ω(fetch("If-None-Match", etag).Code).Should(Equal(304))
+
+		Ω(keys.Announce("two", ecKey1)).Should(Succeed())
+		Ω(fetch("If-None-Match", etag).Code).Should(Equal(200))
+	})
+
+	It("signs tokens with a key ID", func() {
+		tok, err := keys.NewToken(jwtauth.NewClaims("iss", "me", "exp", time.Now().Add(time.Minute).Unix()))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(verify(tok)).Should(Succeed())
+	})
+
+	It("rotates keys", func() {
+		Ω(keys.Rotate()).ShouldNot(Succeed())
+		Ω(keys.Announce("two", ecKey1)).Should(Succeed())
+		Ω(published()).Should(Equal([]string{"one", "two"}))
+		Ω(keys.KeyID()).Should(Equal("one"))
+
+		old, err := keys.NewToken(jwtauth.NewClaims("iss", "me"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(keys.Rotate()).Should(Succeed())
+		Ω(keys.KeyID()).Should(Equal("two"))
+		Ω(published()).Should(Equal([]string{"two", "one"}))
+
+		tok, err := keys.NewToken(jwtauth.NewClaims("iss", "me"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("ES256"))
+		Ω(verify(tok)).Should(Succeed())
+		Ω(verify(old)).Should(Succeed())
+	})
+
+	It("stops publishing retired keys after a grace period", func() {
+		keys.GracePeriod = 50 * time.Millisecond
+		Ω(keys.Announce("two", ecKey1)).Should(Succeed())
+		old, err := keys.NewToken(jwtauth.NewClaims("iss", "me"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(keys.Rotate()).Should(Succeed())
+		Eventually(published).Should(Equal([]string{"two"}))
+		Ω(verify(old)).Should(HaveResponseStatus(401))
+	})
+
+	It("rejects unsuitable keys", func() {
+		_, err := jwtauth.NewSigningKeySet("one", hmacKey1)
+		Ω(err).Should(HaveOccurred())
+		_, err = jwtauth.NewSigningKeySet("", rsaKey1)
+		Ω(err).Should(HaveOccurred())
+		Ω(keys.Announce("one", rsaKey2)).ShouldNot(Succeed())
+	})
+
+	It("only serves GET and HEAD", func() {
+		w := httptest.NewRecorder()
+		keys.ServeHTTP(w, httptest.NewRequest("POST", jwtauth.JWKSPath, strings.NewReader("")))
+		Ω(w.Code).Should(Equal(405))
+	})
+})
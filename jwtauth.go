@@ -3,6 +3,7 @@ package jwtauth
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/goadesign/goa"
 )
@@ -22,6 +23,28 @@ type (
 		Get(issuer string) interface{}
 	}
 
+	// RevocationStore interface
+	//
+	// When the authentication middleware is configured with the Revocation
+	// option, it consults a RevocationStore after verifying each token, and
+	// rejects tokens that have been revoked individually ("jti" claim), by
+	// session ("sid" claim), or by subject if they were issued before the
+	// subject's revocation time. Subjects are identified by the "iss" and
+	// "sub" claims together, since different issuers may use the same
+	// subject identifiers for different principals.
+	RevocationStore interface {
+		// TokenRevoked reports whether the token with the given ID has been
+		// revoked.
+		TokenRevoked(jti string) bool
+		// SubjectRevokedBefore returns the time before which every token
+		// that the issuer issued to the subject is revoked, or the zero time
+		// if none are.
+		SubjectRevokedBefore(iss, sub string) time.Time
+		// SessionRevoked reports whether the session with the given ID has
+		// been revoked.
+		SessionRevoked(sid string) bool
+	}
+
 	// ExtractionFunc is an optional callback that allows you to customize
 	// jwtauth's handling of JSON Web Tokens during authentication.
 	//
@@ -35,4 +58,18 @@ type (
 	// AuthorizationFunc is an optional callback that allows customization
 	// of the way the middleware authorizes each request.
 	AuthorizationFunc func(context.Context, Claims) error
+
+	// PassphraseFunc is an optional callback that supplies the password for an
+	// encrypted private key. It receives the type of the PEM block that is
+	// being decrypted, e.g. "ENCRYPTED PRIVATE KEY".
+	PassphraseFunc func(blockType string) ([]byte, error)
+
+	// Option customizes the behavior of the authentication middleware; see
+	// AuthenticateWithOptions.
+	Option func(*options)
+
+	// EventFunc is a callback that is notified of changes to the keys that a
+	// keystore trusts. Event functions are called synchronously and should
+	// return quickly.
+	EventFunc func(KeystoreEvent)
 )
@@ -17,46 +17,167 @@ var pemBlock = regexp.MustCompile("^[\r\n]*---+ *BEGIN")
 //
 // LoadKey returns a different type depending on the value of material:
 //
-// If material is a []byte that contains a PEM-encoded PKIX key (e.g. "BEGIN
-// PUBLIC KEY"), LoadKey parses it and returns a single public or private key
-// of an algorithm-specific type.
+// If material is a []byte that contains a PEM-encoded key (e.g. "BEGIN
+// PUBLIC KEY" or "BEGIN PRIVATE KEY"), LoadKey parses it and returns a single
+// public or private key of an algorithm-specific type. If the PEM block is an
+// X.509 certificate, LoadKey returns the certificate's public key. If material
+// contains several PEM blocks, only the first key is returned; see LoadKeys.
+//
+// If material is a JSON document containing a JWK or a JWK Set (RFC 7517),
+// LoadKey parses it and returns the first key. Use ParseJWK or ParseJWKSet to
+// access the key metadata (kid, alg and use).
 //
 // If material is any other []byte, LoadKey returns it unmodified so that it can
 // be used as an HMAC key.
 //
 // Because LoadKey is designed to be used at startup, it panics if the PEM block
-// is malformed.
+// is malformed. To handle errors gracefully (e.g. when reloading keys at
+// runtime), use ParseKey instead.
 func LoadKey(material []byte) interface{} {
-	if pemBlock.Match(material) {
-		parsed, err := parseKey(material)
+	key, err := ParseKey(material)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// LoadKeys is like LoadKey, but it returns every key contained in material,
+// which is useful for certificate chains, key bundles and JWK Sets. Each PEM
+// block or JWK yields one key, in the order they appear.
+//
+// If material is neither PEM nor JSON, LoadKeys returns a single HMAC key.
+//
+// Like LoadKey, LoadKeys panics if any PEM block is malformed.
+func LoadKeys(material []byte) []interface{} {
+	keys, err := ParseKeys(material)
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+// ParseKey is like LoadKey, but returns an error instead of panicking if
+// material is malformed. The error identifies the offending PEM block by
+// position and type, or the offending JWK by key ID and type.
+//
+// ParseKey cannot decrypt password-protected private keys; use
+// ParseKeyWithPassphrase for those.
+func ParseKey(material []byte) (interface{}, error) {
+	return ParseKeyWithPassphrase(material, nil)
+}
+
+// ParseKeys is like LoadKeys, but returns an error instead of panicking if
+// any PEM block or JWK is malformed.
+func ParseKeys(material []byte) ([]interface{}, error) {
+	switch {
+	case pemBlock.Match(material):
+		return parseKeys(material, nil)
+	case jwkDocument.Match(material):
+		return parseJWKs(material)
+	default:
+		return []interface{}{material}, nil
+	}
+}
+
+// ParseKeyWithPassphrase is like ParseKey, but it can also decrypt
+// password-protected private keys, calling passphrase to obtain the password
+// when it encounters an encrypted PEM block. Both PKCS8 "ENCRYPTED PRIVATE
+// KEY" blocks (PBES2 with AES-CBC) and legacy OpenSSL blocks with a
+// "Proc-Type: 4,ENCRYPTED" header are supported.
+func ParseKeyWithPassphrase(material []byte, passphrase PassphraseFunc) (interface{}, error) {
+	var keys []interface{}
+	var err error
+
+	switch {
+	case pemBlock.Match(material):
+		keys, err = parseKeys(material, passphrase)
+	case jwkDocument.Match(material):
+		keys, err = parseJWKs(material)
+	default:
+		return material, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// Parse every public or private key from PEM-formatted ASCII text.
+func parseKeys(pemBlock []byte, passphrase PassphraseFunc) ([]interface{}, error) {
+	var keys []interface{}
+
+	rest := pemBlock
+	for n := 1; ; n++ {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "EC PARAMETERS" {
+			// emitted by "openssl ecparam -genkey"; the key block follows
+			continue
+		}
+		key, err := parseBlock(block, passphrase)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("PEM block %d (%s): %s", n, block.Type, err)
 		}
-		return parsed
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Input does not appear to be a PEM block")
 	}
-	return material
+	return keys, nil
 }
 
-// Parse a public key from a block of PEM-formatted ASCII text.
-func parseKey(pemBlock []byte) (interface{}, error) {
-	block, _ := pem.Decode([]byte(pemBlock))
+// Parse a public or private key from a single PEM block, decrypting it first
+// if necessary.
+func parseBlock(block *pem.Block, passphrase PassphraseFunc) (interface{}, error) {
+	der := block.Bytes
 
-	if block != nil {
-		switch block.Type {
-		case "RSA PUBLIC KEY": // PKCS1 RSA public key
-			key := rsa.PublicKey{N: new(big.Int), E: 0}
-			_, err := asn1.Unmarshal(block.Bytes, &key)
-			return &key, err
-		case "PUBLIC KEY": // PKIX algorithm-neutral public key
-			return x509.ParsePKIXPublicKey(block.Bytes)
-		case "RSA PRIVATE KEY": // PKCS1 RSA private key
-			return x509.ParsePKCS1PrivateKey(block.Bytes)
-		case "EC PRIVATE KEY":
-			return x509.ParseECPrivateKey(block.Bytes)
-		default:
-			return nil, fmt.Errorf("Unsupported PEM block type: %s", block.Type)
+	// Legacy "Proc-Type: 4,ENCRYPTED" PEM is insecure, but still common.
+	if x509.IsEncryptedPEMBlock(block) || block.Type == "ENCRYPTED PRIVATE KEY" {
+		if passphrase == nil {
+			return nil, fmt.Errorf("key is encrypted, but no passphrase was provided")
+		}
+		password, err := passphrase(block.Type)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			der, err = decryptPKCS8(der, password)
+			if err != nil {
+				return nil, err
+			}
+			return x509.ParsePKCS8PrivateKey(der)
+		}
+		der, err = x509.DecryptPEMBlock(block, password)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil, fmt.Errorf("Input does not appear to be a PEM block")
+	switch block.Type {
+	case "RSA PUBLIC KEY": // PKCS1 RSA public key
+		key := rsa.PublicKey{N: new(big.Int), E: 0}
+		_, err := asn1.Unmarshal(der, &key)
+		return &key, err
+	case "PUBLIC KEY": // PKIX algorithm-neutral public key
+		return x509.ParsePKIXPublicKey(der)
+	case "RSA PRIVATE KEY": // PKCS1 RSA private key
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY": // PKCS8 algorithm-neutral private key
+		return x509.ParsePKCS8PrivateKey(der)
+	case "CERTIFICATE": // X.509 certificate; we only care about its key
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type")
+	}
 }
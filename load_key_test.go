@@ -2,7 +2,9 @@ package jwtauth_test
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"fmt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -27,6 +29,12 @@ var _ = Describe("LoadKey", func() {
 		Expect(ok).To(BeTrue())
 	})
 
+	It("loads PKCS8 Ed25519 private keys", func() {
+		key := jwtauth.LoadKey(edKey1Pem)
+		_, ok := key.(ed25519.PrivateKey)
+		Expect(ok).To(BeTrue())
+	})
+
 	It("loads PKCS1 RSA public keys", func() {
 		key := jwtauth.LoadKey(rsaPKCSPubPem)
 		_, ok := key.(*rsa.PublicKey)
@@ -45,6 +53,53 @@ var _ = Describe("LoadKey", func() {
 		Expect(ok).To(BeTrue())
 	})
 
+	It("loads PKIX Ed25519 public keys", func() {
+		key := jwtauth.LoadKey(edPKIXPubPem)
+		Expect(key).To(Equal(edKey1.Public()))
+	})
+
+	It("loads PKCS8 RSA and EC private keys", func() {
+		key := jwtauth.LoadKey(rsaPKCS8Pem)
+		Expect(key).To(Equal(rsaKey1))
+
+		key = jwtauth.LoadKey(ecPKCS8Pem)
+		Expect(key).To(Equal(ecKey1))
+	})
+
+	It("loads public keys from X.509 certificates", func() {
+		key := jwtauth.LoadKey(ecCertPem)
+		Expect(key).To(Equal(ecKey1.Public()))
+
+		key = jwtauth.LoadKey(rsaCertPem)
+		Expect(key).To(Equal(rsaKey1.Public()))
+	})
+
+	It("loads the first key of a bundle", func() {
+		bundle := append(append([]byte{}, ecCertPem...), rsaCertPem...)
+		key := jwtauth.LoadKey(bundle)
+		Expect(key).To(Equal(ecKey1.Public()))
+	})
+
+	It("skips EC PARAMETERS blocks", func() {
+		params := []byte("-----BEGIN EC PARAMETERS-----\nBggqhkjOPQMBBw==\n-----END EC PARAMETERS-----\n")
+		key := jwtauth.LoadKey(append(params, ecKey1Pem...))
+		Expect(key).To(Equal(ecKey1))
+	})
+
+	It("loads JWKs", func() {
+		key := jwtauth.LoadKey(privateJWK(ecKey1))
+		Expect(key).To(Equal(ecKey1))
+	})
+
+	It("refuses to treat malformed JWKs as HMAC keys", func() {
+		Expect(func() {
+			jwtauth.LoadKey([]byte(`{"kty":"RSA","n":"AQAB"}`))
+		}).To(Panic())
+		Expect(func() {
+			jwtauth.LoadKey([]byte(` {"kty":`))
+		}).To(Panic())
+	})
+
 	It("refuses to load garbage", func() {
 		garbage := []byte("-----BEGIN DELICIOUS CHEESE-----\nyum\n-----END DELICIOUS CHEESE-----")
 		Expect(func() {
@@ -52,3 +107,92 @@ var _ = Describe("LoadKey", func() {
 		}).To(Panic())
 	})
 })
+
+var _ = Describe("LoadKeys", func() {
+	It("loads HMAC keys", func() {
+		keys := jwtauth.LoadKeys(hmacKey1)
+		Expect(keys).To(Equal([]interface{}{hmacKey1}))
+	})
+
+	It("loads every key of a bundle, in order", func() {
+		bundle := append(append(append([]byte{}, ecCertPem...), rsaCertPem...), edPKIXPubPem...)
+		keys := jwtauth.LoadKeys(bundle)
+		Expect(keys).To(HaveLen(3))
+		Expect(keys[0]).To(Equal(ecKey1.Public()))
+		Expect(keys[1]).To(Equal(rsaKey1.Public()))
+		Expect(keys[2]).To(Equal(edKey1.Public()))
+	})
+
+	It("refuses to load bundles containing garbage", func() {
+		garbage := []byte("-----BEGIN DELICIOUS CHEESE-----\neXVt\n-----END DELICIOUS CHEESE-----")
+		Expect(func() {
+			jwtauth.LoadKeys(append(append([]byte{}, ecCertPem...), garbage...))
+		}).To(Panic())
+	})
+})
+
+var _ = Describe("ParseKey", func() {
+	passphrase := func(string) ([]byte, error) {
+		return []byte("open-sesame"), nil
+	}
+
+	It("parses keys", func() {
+		key, err := jwtauth.ParseKey(ecKey1Pem)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(Equal(ecKey1))
+
+		key, err = jwtauth.ParseKey(hmacKey1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(Equal(hmacKey1))
+	})
+
+	It("names the offending block", func() {
+		bundle := append(append([]byte{}, ecCertPem...), []byte("-----BEGIN CERTIFICATE-----\neXVt\n-----END CERTIFICATE-----\n")...)
+		_, err := jwtauth.ParseKeys(bundle)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("PEM block 2 (CERTIFICATE): "))
+
+		_, err = jwtauth.ParseKey([]byte("-----BEGIN DELICIOUS CHEESE-----\neXVt\n-----END DELICIOUS CHEESE-----"))
+		Expect(err).To(MatchError("PEM block 1 (DELICIOUS CHEESE): unsupported PEM block type"))
+	})
+
+	It("refuses encrypted keys", func() {
+		_, err := jwtauth.ParseKey(ecPKCS8EncryptedPem)
+		Expect(err).To(MatchError(ContainSubstring("no passphrase")))
+	})
+
+	Context("given a passphrase", func() {
+		It("decrypts PKCS8 keys", func() {
+			key, err := jwtauth.ParseKeyWithPassphrase(ecPKCS8EncryptedPem, passphrase)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(ecKey1))
+
+			key, err = jwtauth.ParseKeyWithPassphrase(rsaPKCS8EncryptedPem, passphrase)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(rsaKey1))
+		})
+
+		It("decrypts legacy OpenSSL keys", func() {
+			key, err := jwtauth.ParseKeyWithPassphrase(ecLegacyEncryptedPem, passphrase)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(ecKey1))
+		})
+
+		It("reports incorrect passwords", func() {
+			wrong := func(string) ([]byte, error) {
+				return []byte("open-barley"), nil
+			}
+			_, err := jwtauth.ParseKeyWithPassphrase(ecPKCS8EncryptedPem, wrong)
+			Expect(err).To(MatchError(ContainSubstring("ENCRYPTED PRIVATE KEY")))
+			Expect(err).To(MatchError(ContainSubstring("password incorrect")))
+		})
+
+		It("reports passphrase errors", func() {
+			failing := func(string) ([]byte, error) {
+				return nil, fmt.Errorf("vault is sealed")
+			}
+			_, err := jwtauth.ParseKeyWithPassphrase(ecLegacyEncryptedPem, failing)
+			Expect(err).To(MatchError("PEM block 1 (EC PRIVATE KEY): vault is sealed"))
+		})
+	})
+})
@@ -0,0 +1,70 @@
+package jwtauth
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryRefreshStore is a concurrency-safe, in-memory RefreshStore. All
+	// methods are safe to call on the zero value of this type.
+	//
+	// Because it is not shared between processes, MemoryRefreshStore is only
+	// suitable for services that run a single instance, and for testing.
+	MemoryRefreshStore struct {
+		mu       sync.Mutex
+		families map[string]refreshFamily
+	}
+
+	// refreshFamily is the state of a family of refresh tokens.
+	refreshFamily struct {
+		current string
+		expires time.Time
+	}
+)
+
+// CreateFamily implements jwtauth.RefreshStore#CreateFamily
+func (ms *MemoryRefreshStore) CreateFamily(family, jti string, expires time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if ms.families == nil {
+		ms.families = map[string]refreshFamily{}
+	}
+	for id, f := range ms.families {
+		if now.After(f.expires) {
+			delete(ms.families, id)
+		}
+	}
+	ms.families[family] = refreshFamily{current: jti, expires: expires}
+	return nil
+}
+
+// RotateFamily implements jwtauth.RefreshStore#RotateFamily
+func (ms *MemoryRefreshStore) RotateFamily(family, old, new string, expires time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	f, ok := ms.families[family]
+	if !ok || f.current != old || time.Now().After(f.expires) {
+		return false, nil
+	}
+	ms.families[family] = refreshFamily{current: new, expires: expires}
+	return true, nil
+}
+
+// RevokeFamily implements jwtauth.RefreshStore#RevokeFamily
+func (ms *MemoryRefreshStore) RevokeFamily(family string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.families, family)
+	return nil
+}
+
+// Len returns the number of families that the store remembers.
+func (ms *MemoryRefreshStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return len(ms.families)
+}
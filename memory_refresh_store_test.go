@@ -0,0 +1,44 @@
+package jwtauth_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("MemoryRefreshStore", func() {
+	var store *jwtauth.MemoryRefreshStore
+	later := time.Now().Add(time.Hour)
+
+	BeforeEach(func() {
+		store = &jwtauth.MemoryRefreshStore{}
+	})
+
+	It("initializes itself", func() {
+		Ω(store.Len()).Should(Equal(0))
+		Ω(store.RotateFamily("f1", "a", "b", later)).Should(BeFalse())
+		Ω(store.RevokeFamily("f1")).Should(Succeed())
+	})
+
+	It("rotates the current token only", func() {
+		Ω(store.CreateFamily("f1", "a", later)).Should(Succeed())
+		Ω(store.RotateFamily("f1", "a", "b", later)).Should(BeTrue())
+		Ω(store.RotateFamily("f1", "a", "c", later)).Should(BeFalse())
+		Ω(store.RotateFamily("f1", "b", "c", later)).Should(BeTrue())
+	})
+
+	It("revokes families", func() {
+		Ω(store.CreateFamily("f1", "a", later)).Should(Succeed())
+		Ω(store.RevokeFamily("f1")).Should(Succeed())
+		Ω(store.RotateFamily("f1", "a", "b", later)).Should(BeFalse())
+	})
+
+	It("forgets families after they expire", func() {
+		Ω(store.CreateFamily("f1", "a", time.Now().Add(-time.Second))).Should(Succeed())
+		Ω(store.RotateFamily("f1", "a", "b", later)).Should(BeFalse())
+		Ω(store.CreateFamily("f2", "a", later)).Should(Succeed())
+		Ω(store.Len()).Should(Equal(1))
+	})
+})
@@ -0,0 +1,125 @@
+package jwtauth
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryRevocationStore is a concurrency-safe, in-memory RevocationStore.
+	// All methods are safe to call on the zero value of this type.
+	//
+	// Revoked tokens and sessions can be given an expiry time, after which
+	// the store forgets them; set it to the "exp" of the token (or the longest
+	// lifetime of any token in the session) to keep the store from growing
+	// forever.
+	MemoryRevocationStore struct {
+		mu       sync.RWMutex
+		tokens   map[string]time.Time
+		subjects map[revokedSubject]time.Time
+		sessions map[string]time.Time
+	}
+
+	// revokedSubject identifies a subject of a particular issuer.
+	revokedSubject struct {
+		iss string
+		sub string
+	}
+)
+
+// RevokeToken revokes the token with the given ID ("jti" claim). The store
+// forgets the token after until, unless it is zero.
+func (ms *MemoryRevocationStore) RevokeToken(jti string, until time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.tokens = revoke(ms.tokens, jti, until)
+}
+
+// RevokeSubject revokes every token that an issuer ("iss" claim) issued to
+// a subject ("sub" claim) before a certain time, e.g. when the subject logs
+// out of every session or changes their password. Tokens that other issuers
+// issued to the same subject are not affected. Calling RevokeSubject again
+// moves the time.
+func (ms *MemoryRevocationStore) RevokeSubject(iss, sub string, before time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.subjects == nil {
+		ms.subjects = map[revokedSubject]time.Time{}
+	}
+	ms.subjects[revokedSubject{iss, sub}] = before
+}
+
+// RevokeSession revokes every token that belongs to a session ("sid"
+// claim). The store forgets the session after until, unless it is zero.
+func (ms *MemoryRevocationStore) RevokeSession(sid string, until time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.sessions = revoke(ms.sessions, sid, until)
+}
+
+// TokenRevoked implements jwtauth.RevocationStore#TokenRevoked
+func (ms *MemoryRevocationStore) TokenRevoked(jti string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return isRevoked(ms.tokens, jti)
+}
+
+// SubjectRevokedBefore implements jwtauth.RevocationStore#SubjectRevokedBefore
+func (ms *MemoryRevocationStore) SubjectRevokedBefore(iss, sub string) time.Time {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.subjects[revokedSubject{iss, sub}]
+}
+
+// SessionRevoked implements jwtauth.RevocationStore#SessionRevoked
+func (ms *MemoryRevocationStore) SessionRevoked(sid string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return isRevoked(ms.sessions, sid)
+}
+
+// revoke adds an ID to a map of revoked IDs, and removes the IDs that the
+// store no longer needs to remember.
+func revoke(revoked map[string]time.Time, id string, until time.Time) map[string]time.Time {
+	now := time.Now()
+	if revoked == nil {
+		revoked = map[string]time.Time{}
+	}
+	for other, t := range revoked {
+		if !t.IsZero() && !now.Before(t) {
+			delete(revoked, other)
+		}
+	}
+	revoked[id] = until
+	return revoked
+}
+
+// isRevoked determines whether an ID is in a map of revoked IDs.
+func isRevoked(revoked map[string]time.Time, id string) bool {
+	until, ok := revoked[id]
+	return ok && (until.IsZero() || time.Now().Before(until))
+}
+
+// checkRevocation returns an error if a token has been revoked.
+func checkRevocation(store RevocationStore, claims Claims) error {
+	if jti := claims.String("jti"); jti != "" && store.TokenRevoked(jti) {
+		return ErrInvalidToken("Revoked", "jti", jti)
+	}
+
+	if sub := claims.Subject(); sub != "" {
+		// "iat" has a resolution of one second; tokens that were issued
+		// during the second of revocation are revoked, too.
+		iss := claims.Issuer()
+		before := store.SubjectRevokedBefore(iss, sub)
+		if _, ok := claims["iat"]; !before.IsZero() && (!ok || !claims.IssuedAt().After(before)) {
+			return ErrInvalidToken("Revoked", "iss", iss, "sub", sub)
+		}
+	}
+
+	if sid := claims.String("sid"); sid != "" && store.SessionRevoked(sid) {
+		return ErrInvalidToken("Revoked", "sid", sid)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package jwtauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("MemoryRevocationStore", func() {
+	var store *jwtauth.MemoryRevocationStore
+
+	BeforeEach(func() {
+		store = &jwtauth.MemoryRevocationStore{}
+	})
+
+	It("initializes itself", func() {
+		Ω(store.TokenRevoked("abc")).Should(BeFalse())
+		Ω(store.SubjectRevokedBefore("login", "alice")).Should(BeZero())
+		Ω(store.SessionRevoked("s1")).Should(BeFalse())
+	})
+
+	It("revokes tokens and sessions", func() {
+		store.RevokeToken("abc", time.Time{})
+		store.RevokeSession("s1", time.Now().Add(time.Hour))
+		Ω(store.TokenRevoked("abc")).Should(BeTrue())
+		Ω(store.TokenRevoked("def")).Should(BeFalse())
+		Ω(store.SessionRevoked("s1")).Should(BeTrue())
+	})
+
+	It("forgets revocations after they expire", func() {
+		store.RevokeToken("abc", time.Now().Add(-time.Second))
+		Ω(store.TokenRevoked("abc")).Should(BeFalse())
+	})
+
+	It("revokes subjects", func() {
+		before := time.Now()
+		store.RevokeSubject("login", "alice", before)
+		Ω(store.SubjectRevokedBefore("login", "alice")).Should(Equal(before))
+		Ω(store.SubjectRevokedBefore("login", "bob")).Should(BeZero())
+		Ω(store.SubjectRevokedBefore("partner", "alice")).Should(BeZero())
+	})
+
+	Context("with the authentication middleware", func() {
+		var stack goa.Handler
+		var resp *httptest.ResponseRecorder
+		var req *http.Request
+
+		BeforeEach(func() {
+			resp = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "http://example.com/", nil)
+			stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return nil
+			}
+			stack = jwtauth.AuthenticateWithOptions(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1},
+				jwtauth.Revocation(store))(stack)
+		})
+
+		authenticate := func(keyvals ...interface{}) error {
+			token, err := jwtauth.NewToken(hmacKey1, jwtauth.NewClaims(keyvals...))
+			Ω(err).ShouldNot(HaveOccurred())
+			setBearerHeader(req, token)
+			return stack(context.Background(), resp, req)
+		}
+
+		It("accepts tokens that are not revoked", func() {
+			store.RevokeToken("abc", time.Time{})
+			Ω(authenticate("jti", "def", "sub", "alice", "sid", "s1")).ShouldNot(HaveOccurred())
+			Ω(authenticate()).ShouldNot(HaveOccurred())
+		})
+
+		It("rejects revoked tokens", func() {
+			store.RevokeToken("abc", time.Time{})
+			err := authenticate("jti", "abc")
+			Ω(err).Should(HaveResponseStatus(401))
+			Ω(err).Should(HaveDetailSubstring("Revoked"))
+			Ω(err).Should(HaveMetaKey("jti"))
+		})
+
+		It("rejects revoked sessions", func() {
+			store.RevokeSession("s1", time.Time{})
+			err := authenticate("sid", "s1")
+			Ω(err).Should(HaveDetailSubstring("Revoked"))
+			Ω(err).Should(HaveMetaKey("sid"))
+		})
+
+		It("rejects tokens issued to a subject before revocation", func() {
+			now := time.Now()
+			store.RevokeSubject("login", "alice", now.Add(-time.Minute))
+
+			err := authenticate("iss", "login", "sub", "alice", "iat", now.Add(-time.Hour).Unix())
+			Ω(err).Should(HaveDetailSubstring("Revoked"))
+			Ω(err).Should(HaveMetaKey("iss"))
+			Ω(err).Should(HaveMetaKey("sub"))
+			Ω(authenticate("iss", "login", "sub", "alice")).Should(HaveDetailSubstring("Revoked"))
+
+			Ω(authenticate("iss", "login", "sub", "alice", "iat", now.Unix())).ShouldNot(HaveOccurred())
+			Ω(authenticate("iss", "login", "sub", "bob", "iat", now.Add(-time.Hour).Unix())).ShouldNot(HaveOccurred())
+			Ω(authenticate("iss", "partner", "sub", "alice", "iat", now.Add(-time.Hour).Unix())).ShouldNot(HaveOccurred())
+		})
+	})
+})
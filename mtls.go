@@ -0,0 +1,42 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+)
+
+// CertificateThumbprint computes the SHA-256 thumbprint of a certificate as
+// used by the "x5t#S256" confirmation method of RFC 8705: the
+// base64url-encoded SHA-256 digest of its DER encoding.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// checkCertificateBinding verifies that a certificate-bound token (RFC 8705)
+// was presented over a TLS connection that was authenticated with the
+// client certificate to which it is bound.
+func checkCertificateBinding(req *http.Request, claims Claims, required bool) error {
+	var x5t string
+	if cnf, ok := claims["cnf"].(map[string]interface{}); ok {
+		x5t, _ = cnf["x5t#S256"].(string)
+	}
+
+	if x5t == "" {
+		if required {
+			return ErrInvalidToken("Certificate-bound token required")
+		}
+		return nil
+	}
+
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return ErrInvalidToken("Token is bound to a client certificate, but none was presented")
+	}
+
+	if actual := CertificateThumbprint(req.TLS.PeerCertificates[0]); actual != x5t {
+		return ErrInvalidToken("Token is not bound to the client certificate", "x5t#S256", actual)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package jwtauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("CertificateBinding", func() {
+	var ecCert, rsaCert *x509.Certificate
+	var stack goa.Handler
+	var req *http.Request
+
+	parseCert := func(data []byte) *x509.Certificate {
+		block, _ := pem.Decode(data)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Ω(err).ShouldNot(HaveOccurred())
+		return cert
+	}
+
+	authenticate := func(cert *x509.Certificate, cnf map[string]interface{}) error {
+		claims := jwtauth.NewClaims("iss", "alice", "sub", "bob", "exp", time.Now().Add(time.Hour).Unix())
+		if cnf != nil {
+			claims["cnf"] = cnf
+		}
+		token, err := jwtauth.NewToken(hmacKey1, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		setBearerHeader(req, token)
+
+		req.TLS = nil
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		}
+		return stack(context.Background(), httptest.NewRecorder(), req)
+	}
+
+	build := func(required bool) {
+		stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return nil
+		}
+		stack = jwtauth.AuthenticateWithOptions(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1},
+			jwtauth.CertificateBinding(required))(stack)
+	}
+
+	BeforeEach(func() {
+		ecCert, rsaCert = parseCert(ecCertPem), parseCert(rsaCertPem)
+		req, _ = http.NewRequest("GET", "https://example.com/", nil)
+		build(false)
+	})
+
+	It("computes thumbprints", func() {
+		Ω(jwtauth.CertificateThumbprint(ecCert)).Should(HaveLen(43))
+		Ω(jwtauth.CertificateThumbprint(ecCert)).ShouldNot(Equal(jwtauth.CertificateThumbprint(rsaCert)))
+	})
+
+	It("accepts tokens bound to the client certificate", func() {
+		cnf := map[string]interface{}{"x5t#S256": jwtauth.CertificateThumbprint(ecCert)}
+		Ω(authenticate(ecCert, cnf)).Should(Succeed())
+	})
+
+	It("rejects tokens bound to another certificate", func() {
+		cnf := map[string]interface{}{"x5t#S256": jwtauth.CertificateThumbprint(ecCert)}
+		err := authenticate(rsaCert, cnf)
+		Ω(err).Should(HaveResponseStatus(401))
+		Ω(err).Should(HaveDetailSubstring("not bound to the client certificate"))
+		Ω(err).Should(HaveMetaKey("x5t#S256"))
+	})
+
+	It("rejects bound tokens without a client certificate", func() {
+		cnf := map[string]interface{}{"x5t#S256": jwtauth.CertificateThumbprint(ecCert)}
+		Ω(authenticate(nil, cnf)).Should(HaveDetailSubstring("none was presented"))
+	})
+
+	It("accepts unbound tokens unless binding is required", func() {
+		Ω(authenticate(nil, nil)).Should(Succeed())
+		Ω(authenticate(ecCert, map[string]interface{}{"jkt": "x"})).Should(Succeed())
+
+		build(true)
+		Ω(authenticate(ecCert, nil)).Should(HaveDetailSubstring("Certificate-bound token required"))
+	})
+})
@@ -3,21 +3,95 @@ package jwtauth
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type (
 	// NamedKeystore is a concurrency-safe, in-memory Keystore implementation
 	// that allows trust to be granted/revoked from issuers at any time.
 	//
+	// In addition to exact issuer names, NamedKeystore can trust issuer
+	// patterns (see TrustPattern and TrustRegexp) so that a multi-tenant
+	// identity provider can be trusted with a single call. Exact matches
+	// always win over patterns; patterns are tried in the order they were
+	// added.
+	//
+	// Trust in an exact issuer's key can be limited to a validity period (see
+	// TrustUntil and TrustBetween). Outside of that period the key is not
+	// returned, and once the period ends the key is removed from the keystore
+	// and subscribers receive an EventExpired. An issuer may hold several keys
+	// with overlapping periods, which allows keys to be rotated without
+	// downtime.
+	//
 	// All methods are safe to call on the zero value of this type; fields are
 	// initialized as needed.
 	NamedKeystore struct {
 		sync.RWMutex
-		keys map[string]interface{}
+		keys     map[string][]*issuerKey
+		patterns []*issuerPattern
+		events   observers
+	}
+
+	// issuerKey is a key that an exact issuer is trusted with, and the
+	// period during which it is trusted. A zero time means the period is
+	// unbounded on that side.
+	issuerKey struct {
+		key       interface{}
+		notBefore time.Time
+		notAfter  time.Time
+		timer     *time.Timer
+	}
+
+	// issuerPattern associates a key with every issuer that matches a regexp.
+	issuerPattern struct {
+		source string
+		glob   bool
+		re     *regexp.Regexp
+		key    interface{}
+	}
+
+	// TrustedKey describes an issuer (or issuer pattern) that a keystore
+	// trusts, and the key that it trusts for the issuer.
+	TrustedKey struct {
+		// Issuer is the name of the issuer, or a pattern that matches issuer
+		// names.
+		Issuer string
+		// Pattern is "glob" if Issuer was trusted with TrustPattern, "regexp"
+		// if it was trusted with TrustRegexp, or "" if it is an exact name.
+		Pattern string
+		// Key is the trusted key or key set.
+		Key interface{}
+		// Fingerprint identifies Key without revealing it; see Fingerprint.
+		Fingerprint string
+		// NotBefore and NotAfter bound the period during which the key is
+		// trusted; they are zero if the period is unbounded.
+		NotBefore time.Time
+		NotAfter  time.Time
+	}
+
+	// issuerAnnotation identifies the issuer of a serialized key.
+	issuerAnnotation struct {
+		Issuer       string `json:"iss,omitempty"`
+		IssuerGlob   string `json:"iss_glob,omitempty"`
+		IssuerRegexp string `json:"iss_regexp,omitempty"`
+		NotBefore    int64  `json:"nbf,omitempty"`
+		NotAfter     int64  `json:"exp,omitempty"`
+	}
+
+	// namedKeystoreEntry is the serialized form of a key in a NamedKeystore:
+	// a JWK annotated with the issuer that it belongs to.
+	namedKeystoreEntry struct {
+		*jsonWebKey
+		issuerAnnotation
 	}
 
 	privateKey interface {
@@ -31,62 +105,604 @@ type (
 //	   - []byte (for HS tokens)
 //     - *rsa.PublicKey (for RS tokens)
 //     - *ecdsa.PublicKey (for ES tokens)
+//     - ed25519.PublicKey (for EdDSA tokens)
 //
 // As a convenience, it converts the following to a related type:
 //     - string becomes []byte
 //     - *rsa.PrivateKey becomes its public key
 //     - *ecdsa.PrivateKey becomes its public key
+//     - ed25519.PrivateKey becomes its public key
+//
+// To trust several keys for the same issuer (e.g. during key rotation), pass
+// a key set: either a []interface{} of keys (such as the result of LoadKeys),
+// or a *JWKSet. When verifying a token, its "kid" header selects a key from
+// a *JWKSet; otherwise, every key that is compatible with the token's
+// algorithm is tried.
+//
+// An issuer can be trusted with only one key that is not time-limited;
+// trusting it with a different one returns an error. Trusting the same key
+// again has no effect, and keeps any time limit that was placed on it by
+// TrustUntil or TrustBetween.
 func (nk *NamedKeystore) Trust(issuer string, key interface{}) error {
+	return nk.TrustBetween(issuer, key, time.Time{}, time.Time{})
+}
+
+// TrustUntil is like Trust, but trust in the key ends at notAfter. When
+// that time comes, the key is removed from the keystore and subscribers
+// receive an EventExpired; until then, the issuer can be revoked as usual.
+//
+// This is useful during key rotation; for instance, to keep trusting the
+// old key until the new key has been rolled out everywhere, while trusting
+// the new key too:
+//
+//     store.TrustUntil("us.acme.com", oldKey, time.Now().Add(48*time.Hour))
+//     store.Trust("us.acme.com", newKey)
+//
+// If the key is already trusted, TrustUntil changes the time at which trust
+// in it ends. The issuer's other keys are not affected.
+func (nk *NamedKeystore) TrustUntil(issuer string, key interface{}, notAfter time.Time) error {
+	return nk.TrustBetween(issuer, key, time.Time{}, notAfter)
+}
+
+// TrustBetween is like TrustUntil, but the key is not trusted before
+// notBefore either. Either time may be zero to leave that side of the
+// period unbounded.
+func (nk *NamedKeystore) TrustBetween(issuer string, key interface{}, notBefore, notAfter time.Time) error {
+	if !notAfter.IsZero() {
+		if !notAfter.After(time.Now()) {
+			return fmt.Errorf("trust in issuer '%s' would already have ended at %s", issuer, notAfter)
+		}
+		if !notBefore.IsZero() && !notAfter.After(notBefore) {
+			return fmt.Errorf("trust in issuer '%s' would end before it begins", issuer)
+		}
+	}
+
+	key, err := trustableKey(key)
+	if err != nil {
+		return err
+	}
+
+	limited := !notBefore.IsZero() || !notAfter.IsZero()
+
 	nk.Lock()
-	defer nk.Unlock()
 
 	if nk.keys == nil {
-		nk.keys = map[string]interface{}{}
+		nk.keys = map[string][]*issuerKey{}
 	}
 
-	if old, ok := nk.keys[issuer]; ok && !reflect.DeepEqual(old, key) {
-		return fmt.Errorf("already added a key for issuer '%s'; call RemoveKey first", issuer)
+	entries := nk.keys[issuer]
+	for i, ik := range entries {
+		if reflect.DeepEqual(ik.key, key) {
+			if limited {
+				ik.stop()
+				entries[i] = &issuerKey{key: key, notBefore: notBefore, notAfter: notAfter}
+				nk.schedule(issuer, entries[i])
+			}
+			nk.Unlock()
+			return nil
+		}
+	}
+	if !limited {
+		for _, ik := range entries {
+			if !ik.limited() {
+				nk.Unlock()
+				return fmt.Errorf("already added a key for issuer '%s'; call RevokeTrust first, or limit trust in the old key with TrustUntil", issuer)
+			}
+		}
 	}
 
-	// For convenience, turn private keys into public and strings into bytes.
-	switch kt := key.(type) {
-	case privateKey:
-		key = kt.Public()
-	case string:
-		key = []byte(kt)
+	ik := &issuerKey{key: key, notBefore: notBefore, notAfter: notAfter}
+	nk.keys[issuer] = append(entries, ik)
+	nk.schedule(issuer, ik)
+
+	nk.Unlock()
+
+	nk.events.emit(KeystoreEvent{Type: EventTrusted, Issuer: issuer, Key: key})
+	return nil
+}
+
+// Subscribe registers a function to be notified whenever an issuer becomes
+// trusted, is revoked or expires, and returns a function that cancels the
+// subscription. Events are delivered synchronously, in the goroutine that
+// made the change, after the keystore's lock has been released.
+func (nk *NamedKeystore) Subscribe(fn EventFunc) (unsubscribe func()) {
+	return nk.events.subscribe(fn)
+}
+
+// TrustPattern grants trust in every issuer that matches a glob-style
+// pattern, in which "*" matches one or more characters other than "/". The
+// text matched by the first "*" is the issuer's tenant, which the
+// authentication middleware adds to the request context (see ContextTenant).
+//
+// For example, the following trusts every tenant of a multi-tenant identity
+// provider using the provider's key set:
+//
+//     store.TrustPattern("https://login.example.com/*/v2.0", keys)
+//
+// TrustPattern accepts the same key types as Trust. To revoke trust, call
+// RevokeTrust with the same pattern.
+func (nk *NamedKeystore) TrustPattern(pattern string, key interface{}) error {
+	expr := strings.Replace(regexp.QuoteMeta(pattern), `\*`, `([^/]+)`, -1)
+	return nk.trustPattern(pattern, true, regexp.MustCompile("^"+expr+"$"), key)
+}
+
+// TrustRegexp is like TrustPattern, but uses a regular expression to match
+// issuers. The expression should be anchored with ^ and $. The issuer's
+// tenant is the text matched by the subexpression named "tenant" or, if there
+// is no such subexpression, the first parenthesized subexpression.
+//
+// To revoke trust, call RevokeTrust with re.String().
+func (nk *NamedKeystore) TrustRegexp(re *regexp.Regexp, key interface{}) error {
+	return nk.trustPattern(re.String(), false, re, key)
+}
+
+func (nk *NamedKeystore) trustPattern(source string, glob bool, re *regexp.Regexp, key interface{}) error {
+	key, err := trustableKey(key)
+	if err != nil {
+		return err
 	}
 
-	switch kt := key.(type) {
-	case *rsa.PublicKey, *ecdsa.PublicKey, []byte:
-		nk.keys[issuer] = kt
-	default:
-		return fmt.Errorf("unsupported key type %T", key)
+	nk.Lock()
+
+	for _, p := range nk.patterns {
+		if p.source == source {
+			nk.Unlock()
+			if !reflect.DeepEqual(p.key, key) {
+				return fmt.Errorf("already added a key for pattern '%s'; call RevokeTrust first", source)
+			}
+			return nil
+		}
 	}
 
+	nk.patterns = append(nk.patterns, &issuerPattern{source: source, glob: glob, re: re, key: key})
+	nk.Unlock()
+
+	nk.events.emit(KeystoreEvent{Type: EventTrusted, Issuer: source, Key: key})
 	return nil
 }
 
 // RevokeTrust implements jwtauth.Keystore#RevokeTrust
+//
+// In addition to exact issuers, it revokes trust in patterns that were
+// added with TrustPattern or TrustRegexp.
 func (nk *NamedKeystore) RevokeTrust(issuer string) {
+	var revoked []interface{}
+
 	nk.Lock()
-	defer nk.Unlock()
 
-	if nk.keys == nil {
-		return
+	for i, p := range nk.patterns {
+		if p.source == issuer {
+			revoked = append(revoked, p.key)
+			nk.patterns = append(nk.patterns[:i:i], nk.patterns[i+1:]...)
+			break
+		}
 	}
 
-	delete(nk.keys, issuer)
-	return
+	if entries, ok := nk.keys[issuer]; ok {
+		revoked = append(revoked, mergeKeys(entries, time.Time{}))
+		for _, ik := range entries {
+			ik.stop()
+		}
+		delete(nk.keys, issuer)
+	}
+
+	nk.Unlock()
+
+	for _, key := range revoked {
+		nk.events.emit(KeystoreEvent{Type: EventRevoked, Issuer: issuer, Key: key})
+	}
 }
 
 // Get implements jwtauth.Keystore#Get
 func (nk *NamedKeystore) Get(issuer string) interface{} {
+	key, _ := nk.Match(issuer)
+	return key
+}
+
+// Match is like Get, but also returns the tenant if the issuer matched a
+// pattern rather than an exact name.
+func (nk *NamedKeystore) Match(issuer string) (key interface{}, tenant string) {
+	nk.RLock()
+	defer nk.RUnlock()
+
+	if key := mergeKeys(nk.keys[issuer], time.Now()); key != nil {
+		return key, ""
+	}
+
+	for _, p := range nk.patterns {
+		if m := p.re.FindStringSubmatch(issuer); m != nil {
+			return p.key, patternTenant(p.re, m)
+		}
+	}
+
+	return nil, ""
+}
+
+// Issuers returns the names of all trusted issuers in alphabetical order,
+// followed by all trusted issuer patterns in the order they were added.
+func (nk *NamedKeystore) Issuers() []string {
+	var issuers []string
+	for _, tk := range nk.Trusted() {
+		if n := len(issuers); n == 0 || issuers[n-1] != tk.Issuer {
+			issuers = append(issuers, tk.Issuer)
+		}
+	}
+	return issuers
+}
+
+// Trusted returns a snapshot of every trusted issuer and its keys, in the
+// same order as Issuers. An issuer that holds several keys appears once per
+// key.
+func (nk *NamedKeystore) Trusted() []TrustedKey {
 	nk.RLock()
 	defer nk.RUnlock()
 
-	if nk.keys != nil {
-		return nk.keys[issuer]
+	now := time.Now()
+	trusted := make([]TrustedKey, 0, len(nk.keys)+len(nk.patterns))
+	for iss, entries := range nk.keys {
+		for _, ik := range entries {
+			if !ik.notAfter.IsZero() && !now.Before(ik.notAfter) {
+				continue
+			}
+			trusted = append(trusted, TrustedKey{
+				Issuer:      iss,
+				Key:         ik.key,
+				Fingerprint: Fingerprint(ik.key),
+				NotBefore:   ik.notBefore,
+				NotAfter:    ik.notAfter,
+			})
+		}
 	}
+	sort.SliceStable(trusted, func(i, j int) bool {
+		return trusted[i].Issuer < trusted[j].Issuer
+	})
 
+	for _, p := range nk.patterns {
+		tk := TrustedKey{Issuer: p.source, Pattern: "regexp", Key: p.key, Fingerprint: Fingerprint(p.key)}
+		if p.glob {
+			tk.Pattern = "glob"
+		}
+		trusted = append(trusted, tk)
+	}
+
+	return trusted
+}
+
+// MarshalJSON implements json.Marshaler. It serializes the keystore as a JWK
+// Set in which every key has an additional "iss" member that names its
+// issuer (or "iss_glob" or "iss_regexp" for issuer patterns), plus "nbf"
+// and "exp" members if trust in the key is time-limited. Issuers with a
+// key set or several keys contribute one JWK per key.
+//
+// The output contains HMAC secrets, if any are trusted; store it accordingly!
+func (nk *NamedKeystore) MarshalJSON() ([]byte, error) {
+	entries := []namedKeystoreEntry{}
+	for _, tk := range nk.Trusted() {
+		var jwks []*JWK
+		switch kt := tk.Key.(type) {
+		case *JWKSet:
+			jwks = kt.Keys
+		case []interface{}:
+			for _, k := range kt {
+				jwks = append(jwks, &JWK{Key: k})
+			}
+		default:
+			jwks = []*JWK{{Key: kt}}
+		}
+
+		for _, jwk := range jwks {
+			raw, err := jwk.wire()
+			if err != nil {
+				return nil, fmt.Errorf("cannot serialize key for '%s': %s", tk.Issuer, err)
+			}
+			entry := namedKeystoreEntry{jsonWebKey: raw}
+			switch tk.Pattern {
+			case "glob":
+				entry.IssuerGlob = tk.Issuer
+			case "regexp":
+				entry.IssuerRegexp = tk.Issuer
+			default:
+				entry.Issuer = tk.Issuer
+			}
+			if !tk.NotBefore.IsZero() {
+				entry.NotBefore = tk.NotBefore.Unix()
+			}
+			if !tk.NotAfter.IsZero() {
+				entry.NotAfter = tk.NotAfter.Unix()
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{"keys": entries})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It replaces the contents of the
+// keystore with the issuers and keys in a document produced by MarshalJSON.
+// If the document is malformed, the keystore is left unchanged. Keys whose
+// "exp" has passed are ignored. Subscribers are notified of the issuers
+// that were added, removed or given a different key.
+//
+// Issuers that have several keys, or whose key has a "kid", "alg" or "use",
+// are restored with a *JWKSet.
+func (nk *NamedKeystore) UnmarshalJSON(data []byte) error {
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	// Group keys by issuer, remembering the order in which issuers appear.
+	var order []issuerAnnotation
+	sets := map[issuerAnnotation]*JWKSet{}
+	for _, rk := range doc.Keys {
+		var entry issuerAnnotation
+		if err := json.Unmarshal(rk, &entry); err != nil {
+			return err
+		}
+		if entry.Issuer == "" && entry.IssuerGlob == "" && entry.IssuerRegexp == "" {
+			return fmt.Errorf("key has no issuer")
+		}
+		jwk, err := ParseJWK(rk)
+		if err != nil {
+			return err
+		}
+		if sets[entry] == nil {
+			sets[entry] = &JWKSet{}
+			order = append(order, entry)
+		}
+		sets[entry].Keys = append(sets[entry].Keys, jwk)
+	}
+
+	now := time.Now()
+	fresh := &NamedKeystore{}
+	for _, entry := range order {
+		var notBefore, notAfter time.Time
+		if entry.NotBefore != 0 {
+			notBefore = time.Unix(entry.NotBefore, 0)
+		}
+		if entry.NotAfter != 0 {
+			notAfter = time.Unix(entry.NotAfter, 0)
+			if !notAfter.After(now) {
+				continue
+			}
+		}
+
+		var key interface{} = sets[entry]
+		if jwk := sets[entry].Keys; len(jwk) == 1 && jwk[0].KeyID == "" && jwk[0].Algorithm == "" && jwk[0].Use == "" {
+			key = jwk[0].Key
+		}
+
+		var err error
+		switch {
+		case entry.IssuerGlob != "":
+			err = fresh.TrustPattern(entry.IssuerGlob, key)
+		case entry.IssuerRegexp != "":
+			var re *regexp.Regexp
+			if re, err = regexp.Compile(entry.IssuerRegexp); err == nil {
+				err = fresh.TrustRegexp(re, key)
+			}
+		default:
+			err = fresh.TrustBetween(entry.Issuer, key, notBefore, notAfter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The fresh keystore's timers would expire its own keys, not ours.
+	for _, entries := range fresh.keys {
+		for _, ik := range entries {
+			ik.stop()
+		}
+	}
+
+	nk.Lock()
+	old := nk.snapshot()
+	for _, entries := range nk.keys {
+		for _, ik := range entries {
+			ik.stop()
+		}
+	}
+	nk.keys, nk.patterns = fresh.keys, fresh.patterns
+	for iss, entries := range nk.keys {
+		for i, ik := range entries {
+			entries[i] = &issuerKey{key: ik.key, notBefore: ik.notBefore, notAfter: ik.notAfter}
+			nk.schedule(iss, entries[i])
+		}
+	}
+	new := nk.snapshot()
+	nk.Unlock()
+
+	for _, ev := range diffKeys(old, new) {
+		nk.events.emit(ev)
+	}
 	return nil
 }
+
+// snapshot maps every trusted issuer and issuer pattern to its key. The
+// caller must hold the lock.
+func (nk *NamedKeystore) snapshot() map[string]interface{} {
+	keys := make(map[string]interface{}, len(nk.keys)+len(nk.patterns))
+	for iss, entries := range nk.keys {
+		keys[iss] = mergeKeys(entries, time.Time{})
+	}
+	for _, p := range nk.patterns {
+		keys[p.source] = p.key
+	}
+	return keys
+}
+
+// schedule arranges for a key to be removed from the keystore at the end of
+// its trust period, if any. The caller must hold the lock.
+func (nk *NamedKeystore) schedule(issuer string, ik *issuerKey) {
+	if ik.notAfter.IsZero() {
+		return
+	}
+	ik.timer = time.AfterFunc(time.Until(ik.notAfter), func() {
+		nk.Lock()
+		entries := nk.keys[issuer]
+		found := false
+		for i, e := range entries {
+			if e == ik {
+				entries = append(entries[:i:i], entries[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			// Trust was revoked or renewed in the meantime.
+			nk.Unlock()
+			return
+		}
+		if len(entries) == 0 {
+			delete(nk.keys, issuer)
+		} else {
+			nk.keys[issuer] = entries
+		}
+		nk.Unlock()
+
+		nk.events.emit(KeystoreEvent{Type: EventExpired, Issuer: issuer, Key: ik.key, Time: ik.notAfter})
+	})
+}
+
+// stop cancels the removal of a key at the end of its trust period.
+func (ik *issuerKey) stop() {
+	if ik.timer != nil {
+		ik.timer.Stop()
+	}
+}
+
+// limited determines whether trust in a key is limited to a period.
+func (ik *issuerKey) limited() bool {
+	return !ik.notBefore.IsZero() || !ik.notAfter.IsZero()
+}
+
+// contains determines whether t falls within the key's trust period.
+func (ik *issuerKey) contains(t time.Time) bool {
+	if !ik.notBefore.IsZero() && t.Before(ik.notBefore) {
+		return false
+	}
+	return ik.notAfter.IsZero() || t.Before(ik.notAfter)
+}
+
+// mergeKeys returns the key that an issuer is trusted with at a certain
+// time, or every key that it holds if t is zero. If several keys qualify,
+// they are merged into one key set: a *JWKSet if any of them is one (so that
+// "kid" headers still select keys), or else a []interface{}.
+func mergeKeys(entries []*issuerKey, t time.Time) interface{} {
+	var keys []interface{}
+	for _, ik := range entries {
+		if t.IsZero() || ik.contains(t) {
+			keys = append(keys, ik.key)
+		}
+	}
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return keys[0]
+	}
+
+	var flat []interface{}
+	jwks := false
+	for _, key := range keys {
+		switch kt := key.(type) {
+		case []interface{}:
+			flat = append(flat, kt...)
+		case *JWKSet:
+			jwks = true
+			flat = append(flat, kt)
+		default:
+			flat = append(flat, kt)
+		}
+	}
+	if !jwks {
+		return flat
+	}
+
+	set := &JWKSet{}
+	for _, key := range flat {
+		if ks, ok := key.(*JWKSet); ok {
+			set.Keys = append(set.Keys, ks.Keys...)
+		} else {
+			set.Keys = append(set.Keys, &JWK{Key: key})
+		}
+	}
+	return set
+}
+
+// patternTenant picks the tenant out of an issuer pattern's submatches.
+func patternTenant(re *regexp.Regexp, submatches []string) string {
+	for i, name := range re.SubexpNames() {
+		if name == "tenant" {
+			return submatches[i]
+		}
+	}
+	if len(submatches) > 1 {
+		return submatches[1]
+	}
+	return ""
+}
+
+// trustableKey converts a key into a type that is suitable for verifying
+// tokens, or returns an error if that is not possible.
+func trustableKey(key interface{}) (interface{}, error) {
+	// For convenience, turn private keys into public and strings into bytes.
+	switch kt := key.(type) {
+	case privateKey:
+		key = kt.Public()
+	case string:
+		key = []byte(kt)
+	}
+
+	switch kt := key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, []byte:
+		return kt, nil
+	case []interface{}:
+		set := make([]interface{}, len(kt))
+		for i, k := range kt {
+			tk, err := trustableKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if isKeySet(tk) {
+				return nil, fmt.Errorf("key sets cannot be nested")
+			}
+			set[i] = tk
+		}
+		if len(set) == 0 {
+			return nil, fmt.Errorf("key set is empty")
+		}
+		return set, nil
+	case *JWKSet:
+		set := &JWKSet{Keys: make([]*JWK, len(kt.Keys))}
+		for i, jwk := range kt.Keys {
+			tk, err := trustableKey(jwk.Key)
+			if err != nil {
+				return nil, err
+			}
+			if isKeySet(tk) {
+				return nil, fmt.Errorf("key sets cannot be nested")
+			}
+			set.Keys[i] = &JWK{Key: tk, KeyID: jwk.KeyID, Algorithm: jwk.Algorithm, Use: jwk.Use}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("key set is empty")
+		}
+		return set, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// isKeySet determines whether a trusted key is actually a set of keys.
+func isKeySet(key interface{}) bool {
+	switch key.(type) {
+	case []interface{}, *JWKSet:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,6 +1,10 @@
 package jwtauth_test
 
 import (
+	"encoding/json"
+	"regexp"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rightscale/jwtauth"
@@ -52,6 +56,272 @@ var _ = Describe("NamedKeystore", func() {
 		It("converts private keys to public", func() {
 			Ω(store.Trust("bah", rsaKey1)).ShouldNot(HaveOccurred())
 			Ω(store.Trust("oink", ecKey1)).ShouldNot(HaveOccurred())
+			Ω(store.Trust("quack", edKey1)).ShouldNot(HaveOccurred())
+			Ω(store.Get("quack")).Should(Equal(edKey1.Public()))
+		})
+	})
+
+	Context("key sets", func() {
+		It("accepts lists of keys", func() {
+			Ω(store.Trust("bah", []interface{}{rsaKey1, ecKey1})).ShouldNot(HaveOccurred())
+			Ω(store.Get("bah")).Should(Equal([]interface{}{&rsaKey1.PublicKey, &ecKey1.PublicKey}))
+		})
+
+		It("accepts JWK sets", func() {
+			set := &jwtauth.JWKSet{Keys: []*jwtauth.JWK{{Key: rsaKey1, KeyID: "k1"}}}
+			Ω(store.Trust("bah", set)).ShouldNot(HaveOccurred())
+			Ω(store.Get("bah")).Should(Equal(&jwtauth.JWKSet{Keys: []*jwtauth.JWK{{Key: &rsaKey1.PublicKey, KeyID: "k1"}}}))
+		})
+
+		It("rejects empty, nested or invalid sets", func() {
+			Ω(store.Trust("bah", []interface{}{})).Should(HaveOccurred())
+			Ω(store.Trust("bah", []interface{}{[]interface{}{hmacKey1}})).Should(HaveOccurred())
+			Ω(store.Trust("bah", []interface{}{hmacKey1, 666})).Should(HaveOccurred())
+		})
+	})
+
+	Context("TrustPattern()", func() {
+		BeforeEach(func() {
+			Ω(store.TrustPattern("https://login.example.com/*/v2.0", hmacKey2)).ShouldNot(HaveOccurred())
+		})
+
+		It("matches issuers", func() {
+			key, tenant := store.Match("https://login.example.com/acme/v2.0")
+			Ω(key).Should(Equal(hmacKey2))
+			Ω(tenant).Should(Equal("acme"))
+			Ω(store.Get("https://login.example.com/acme/v2.0")).Should(Equal(hmacKey2))
+		})
+
+		It("matches a single path segment", func() {
+			Ω(store.Get("https://login.example.com/a/b/v2.0")).Should(BeNil())
+			Ω(store.Get("https://login.example.com//v2.0")).Should(BeNil())
+			Ω(store.Get("https://login.example.com/acme/v2.0/extra")).Should(BeNil())
+		})
+
+		It("treats other characters literally", func() {
+			Ω(store.Get("https://loginXexample.com/acme/v2.0")).Should(BeNil())
+		})
+
+		It("prefers exact matches", func() {
+			Ω(store.Trust("https://login.example.com/vip/v2.0", hmacKey1)).ShouldNot(HaveOccurred())
+			key, tenant := store.Match("https://login.example.com/vip/v2.0")
+			Ω(key).Should(Equal(hmacKey1))
+			Ω(tenant).Should(Equal(""))
+		})
+
+		It("rejects double-add", func() {
+			Ω(store.TrustPattern("https://login.example.com/*/v2.0", hmacKey2)).ShouldNot(HaveOccurred())
+			Ω(store.TrustPattern("https://login.example.com/*/v2.0", hmacKey1)).Should(HaveOccurred())
+		})
+
+		It("is revoked by RevokeTrust", func() {
+			store.RevokeTrust("https://login.example.com/*/v2.0")
+			Ω(store.Get("https://login.example.com/acme/v2.0")).Should(BeNil())
+		})
+	})
+
+	Context("TrustRegexp()", func() {
+		It("uses the tenant subexpression", func() {
+			re := regexp.MustCompile(`^https://(eu|us)\.example\.com/(?P<tenant>\d+)$`)
+			Ω(store.TrustRegexp(re, hmacKey2)).ShouldNot(HaveOccurred())
+
+			key, tenant := store.Match("https://eu.example.com/1234")
+			Ω(key).Should(Equal(hmacKey2))
+			Ω(tenant).Should(Equal("1234"))
+
+			store.RevokeTrust(re.String())
+			Ω(store.Get("https://eu.example.com/1234")).Should(BeNil())
+		})
+
+		It("falls back to the first subexpression", func() {
+			re := regexp.MustCompile(`^tenant-(.+)$`)
+			Ω(store.TrustRegexp(re, hmacKey2)).ShouldNot(HaveOccurred())
+
+			_, tenant := store.Match("tenant-acme")
+			Ω(tenant).Should(Equal("acme"))
+		})
+	})
+
+	Context("TrustUntil()", func() {
+		It("trusts the key until it expires", func() {
+			Ω(store.TrustUntil("bah", hmacKey2, time.Now().Add(50*time.Millisecond))).ShouldNot(HaveOccurred())
+			Ω(store.Get("bah")).Should(Equal(hmacKey2))
+			Eventually(func() interface{} { return store.Get("bah") }).Should(BeNil())
+			Ω(store.Issuers()).Should(Equal([]string{"moo"}))
+		})
+
+		It("notifies subscribers when the key expires", func() {
+			events := make(chan jwtauth.KeystoreEvent, 1)
+			store.Subscribe(func(ev jwtauth.KeystoreEvent) {
+				if ev.Type == jwtauth.EventExpired {
+					events <- ev
+				}
+			})
+
+			notAfter := time.Now().Add(50 * time.Millisecond)
+			Ω(store.TrustUntil("bah", hmacKey2, notAfter)).ShouldNot(HaveOccurred())
+
+			var ev jwtauth.KeystoreEvent
+			Eventually(events).Should(Receive(&ev))
+			Ω(ev.Type).Should(Equal(jwtauth.EventExpired))
+			Ω(ev.Issuer).Should(Equal("bah"))
+			Ω(ev.Key).Should(Equal(hmacKey2))
+			Ω(ev.Time).Should(Equal(notAfter))
+		})
+
+		It("limits trust in an already-trusted key", func() {
+			Ω(store.TrustUntil("moo", hmacKey1, time.Now().Add(50*time.Millisecond))).ShouldNot(HaveOccurred())
+			Eventually(func() interface{} { return store.Get("moo") }).Should(BeNil())
+		})
+
+		It("is kept by Trust", func() {
+			Ω(store.TrustUntil("moo", hmacKey1, time.Now().Add(50*time.Millisecond))).ShouldNot(HaveOccurred())
+			Ω(store.Trust("moo", hmacKey1)).ShouldNot(HaveOccurred())
+			Eventually(func() interface{} { return store.Get("moo") }).Should(BeNil())
+		})
+
+		It("lets an issuer rotate to a new key", func() {
+			events := make(chan jwtauth.KeystoreEvent, 1)
+			store.Subscribe(func(ev jwtauth.KeystoreEvent) {
+				if ev.Type == jwtauth.EventExpired {
+					events <- ev
+				}
+			})
+
+			Ω(store.TrustUntil("moo", hmacKey1, time.Now().Add(50*time.Millisecond))).ShouldNot(HaveOccurred())
+			Ω(store.Trust("moo", hmacKey2)).ShouldNot(HaveOccurred())
+			Ω(store.Get("moo")).Should(Equal([]interface{}{hmacKey1, hmacKey2}))
+			Ω(store.Trusted()).Should(HaveLen(2))
+			Ω(store.Issuers()).Should(Equal([]string{"moo"}))
+
+			var ev jwtauth.KeystoreEvent
+			Eventually(events).Should(Receive(&ev))
+			Ω(ev.Key).Should(Equal(hmacKey1))
+			Ω(store.Get("moo")).Should(Equal(hmacKey2))
+		})
+
+		It("merges overlapping key sets", func() {
+			set := &jwtauth.JWKSet{Keys: []*jwtauth.JWK{{Key: rsaKey2, KeyID: "k2"}}}
+			Ω(store.TrustUntil("moo", hmacKey1, time.Now().Add(time.Hour))).ShouldNot(HaveOccurred())
+			Ω(store.Trust("moo", set)).ShouldNot(HaveOccurred())
+
+			merged := store.Get("moo").(*jwtauth.JWKSet)
+			Ω(merged.Keys).Should(HaveLen(2))
+			Ω(merged.Keys[0].Key).Should(Equal(hmacKey1))
+			Ω(merged.Keys[1].KeyID).Should(Equal("k2"))
+		})
+
+		It("survives serialization with several keys", func() {
+			notAfter := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+			Ω(store.TrustUntil("moo", hmacKey1, notAfter)).ShouldNot(HaveOccurred())
+			Ω(store.Trust("moo", hmacKey2)).ShouldNot(HaveOccurred())
+
+			data, err := json.Marshal(store)
+			Ω(err).ShouldNot(HaveOccurred())
+			restored := &jwtauth.NamedKeystore{}
+			Ω(json.Unmarshal(data, restored)).Should(Succeed())
+			Ω(restored.Trusted()).Should(Equal(store.Trusted()))
+		})
+
+		It("still allows only one unlimited key", func() {
+			Ω(store.Trust("moo", hmacKey2)).Should(MatchError(ContainSubstring("RevokeTrust")))
+		})
+
+		It("is cancelled by RevokeTrust", func() {
+			events := make(chan jwtauth.KeystoreEvent, 1)
+			store.Subscribe(func(ev jwtauth.KeystoreEvent) {
+				if ev.Type == jwtauth.EventExpired {
+					events <- ev
+				}
+			})
+
+			Ω(store.TrustUntil("bah", hmacKey2, time.Now().Add(50*time.Millisecond))).ShouldNot(HaveOccurred())
+			store.RevokeTrust("bah")
+			Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("rejects periods that have ended", func() {
+			Ω(store.TrustUntil("bah", hmacKey2, time.Now().Add(-time.Second))).Should(HaveOccurred())
+			Ω(store.Get("bah")).Should(BeNil())
+		})
+	})
+
+	Context("TrustBetween()", func() {
+		It("does not trust the key too early", func() {
+			notBefore := time.Now().Add(50 * time.Millisecond)
+			Ω(store.TrustBetween("bah", hmacKey2, notBefore, notBefore.Add(time.Hour))).ShouldNot(HaveOccurred())
+			Ω(store.Get("bah")).Should(BeNil())
+			Eventually(func() interface{} { return store.Get("bah") }).Should(Equal(hmacKey2))
+
+			trusted := store.Trusted()
+			Ω(trusted[0].NotBefore).Should(Equal(notBefore))
+			Ω(trusted[0].NotAfter).Should(Equal(notBefore.Add(time.Hour)))
+		})
+
+		It("round-trips open-ended periods", func() {
+			notBefore := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+			Ω(store.TrustBetween("bah", hmacKey2, notBefore, time.Time{})).ShouldNot(HaveOccurred())
+
+			data, err := json.Marshal(store)
+			Ω(err).ShouldNot(HaveOccurred())
+			restored := &jwtauth.NamedKeystore{}
+			Ω(json.Unmarshal(data, restored)).Should(Succeed())
+			Ω(restored.Get("bah")).Should(BeNil())
+			Ω(restored.Trusted()[0].NotBefore).Should(Equal(notBefore))
+		})
+
+		It("rejects empty periods", func() {
+			notBefore := time.Now().Add(time.Hour)
+			Ω(store.TrustBetween("bah", hmacKey2, notBefore, notBefore)).Should(HaveOccurred())
+		})
+	})
+
+	Context("Subscribe()", func() {
+		var events []jwtauth.KeystoreEvent
+
+		BeforeEach(func() {
+			events = nil
+			store.Subscribe(func(ev jwtauth.KeystoreEvent) { events = append(events, ev) })
+		})
+
+		It("reports trust and revocation", func() {
+			Ω(store.Trust("bah", &rsaKey1.PublicKey)).ShouldNot(HaveOccurred())
+			Ω(store.Trust("bah", &rsaKey1.PublicKey)).ShouldNot(HaveOccurred())
+			Ω(store.TrustPattern("*.example.com", hmacKey2)).ShouldNot(HaveOccurred())
+			store.RevokeTrust("bah")
+			store.RevokeTrust("nobody")
+
+			Ω(events).Should(HaveLen(3))
+			Ω(events[0].Type).Should(Equal(jwtauth.EventTrusted))
+			Ω(events[0].Issuer).Should(Equal("bah"))
+			Ω(events[0].Fingerprint).Should(HaveLen(64))
+			Ω(events[0].Time).ShouldNot(BeZero())
+			Ω(events[1].Issuer).Should(Equal("*.example.com"))
+			Ω(events[2].Type).Should(Equal(jwtauth.EventRevoked))
+			Ω(events[2].Fingerprint).Should(Equal(events[0].Fingerprint))
+		})
+
+		It("reports changes made by deserializing", func() {
+			Ω(store.Trust("bah", hmacKey2)).ShouldNot(HaveOccurred())
+			events = nil
+
+			doc := []byte(`{"keys":[{"kty":"oct","k":"AQ","iss":"bah"},{"kty":"oct","k":"Ag","iss":"oink"}]}`)
+			Ω(json.Unmarshal(doc, store)).Should(Succeed())
+
+			Ω(events).Should(HaveLen(3))
+			Ω(events[0].Type).Should(Equal(jwtauth.EventRotated))
+			Ω(events[0].Key).Should(Equal([]byte{1}))
+			Ω(events[1].Type).Should(Equal(jwtauth.EventRevoked))
+			Ω(events[1].Issuer).Should(Equal("moo"))
+			Ω(events[2].Type).Should(Equal(jwtauth.EventTrusted))
+			Ω(events[2].Issuer).Should(Equal("oink"))
+		})
+
+		It("stops when cancelled", func() {
+			cancel := store.Subscribe(func(jwtauth.KeystoreEvent) { Fail("should not be called") })
+			cancel()
+			store.RevokeTrust("moo")
+			Ω(events).Should(HaveLen(1))
 		})
 	})
 
@@ -70,3 +340,95 @@ var _ = Describe("NamedKeystore", func() {
 		})
 	})
 })
+
+var _ = Describe("NamedKeystore snapshots", func() {
+	var store *jwtauth.NamedKeystore
+
+	BeforeEach(func() {
+		store = &jwtauth.NamedKeystore{}
+		Ω(store.Trust("moo", hmacKey1)).ShouldNot(HaveOccurred())
+		Ω(store.Trust("bah", rsaKey1)).ShouldNot(HaveOccurred())
+		Ω(store.Trust("oink", []interface{}{ecKey1, edKey1})).ShouldNot(HaveOccurred())
+		Ω(store.Trust("quack", &jwtauth.JWKSet{Keys: []*jwtauth.JWK{{Key: rsaKey2, KeyID: "k2", Algorithm: "PS256"}}})).ShouldNot(HaveOccurred())
+		Ω(store.TrustPattern("https://*.example.com", ecKey2)).ShouldNot(HaveOccurred())
+		Ω(store.TrustRegexp(regexp.MustCompile(`^tenant-(\d+)$`), hmacKey2)).ShouldNot(HaveOccurred())
+	})
+
+	It("lists issuers", func() {
+		Ω(store.Issuers()).Should(Equal([]string{"bah", "moo", "oink", "quack", "https://*.example.com", `^tenant-(\d+)$`}))
+	})
+
+	It("lists keys", func() {
+		trusted := store.Trusted()
+		Ω(trusted).Should(HaveLen(6))
+		Ω(trusted[0]).Should(Equal(jwtauth.TrustedKey{Issuer: "bah", Key: &rsaKey1.PublicKey, Fingerprint: jwtauth.Fingerprint(rsaKey1)}))
+		Ω(trusted[4]).Should(Equal(jwtauth.TrustedKey{Issuer: "https://*.example.com", Pattern: "glob", Key: &ecKey2.PublicKey, Fingerprint: jwtauth.Fingerprint(ecKey2)}))
+		Ω(trusted[5].Pattern).Should(Equal("regexp"))
+	})
+
+	It("serializes as an annotated JWK set", func() {
+		data, err := json.Marshal(store)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		set, err := jwtauth.ParseJWKSet(data)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(set.Keys).Should(HaveLen(7))
+
+		var doc struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}
+		Ω(json.Unmarshal(data, &doc)).Should(Succeed())
+		Ω(doc.Keys[0]).Should(HaveKeyWithValue("iss", "bah"))
+		Ω(doc.Keys[5]).Should(HaveKeyWithValue("iss_glob", "https://*.example.com"))
+		Ω(doc.Keys[6]).Should(HaveKeyWithValue("iss_regexp", `^tenant-(\d+)$`))
+	})
+
+	It("round-trips", func() {
+		data, err := json.Marshal(store)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		restored := &jwtauth.NamedKeystore{}
+		Ω(restored.Trust("stale", hmacKey1)).ShouldNot(HaveOccurred())
+		Ω(json.Unmarshal(data, restored)).Should(Succeed())
+
+		Ω(restored.Get("stale")).Should(BeNil())
+		Ω(restored.Get("moo")).Should(Equal(hmacKey1))
+		Ω(restored.Get("bah")).Should(Equal(&rsaKey1.PublicKey))
+		Ω(restored.Get("quack")).Should(Equal(store.Get("quack")))
+		Ω(restored.Get("https://eu.example.com")).Should(Equal(&ecKey2.PublicKey))
+
+		_, tenant := restored.Match("tenant-42")
+		Ω(tenant).Should(Equal("42"))
+
+		oink := restored.Get("oink").(*jwtauth.JWKSet)
+		Ω(oink.Keys).Should(HaveLen(2))
+		Ω(oink.Keys[0].Key).Should(Equal(&ecKey1.PublicKey))
+		Ω(oink.Keys[1].Key).Should(Equal(edKey1.Public()))
+	})
+
+	It("round-trips trust periods", func() {
+		notAfter := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+		Ω(store.TrustUntil("moo", hmacKey1, notAfter)).ShouldNot(HaveOccurred())
+
+		data, err := json.Marshal(store)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(data)).Should(ContainSubstring(`"exp":%d`, notAfter.Unix()))
+
+		restored := &jwtauth.NamedKeystore{}
+		Ω(json.Unmarshal(data, restored)).Should(Succeed())
+		Ω(restored.Trusted()[1].NotAfter).Should(Equal(notAfter))
+	})
+
+	It("drops expired keys when deserializing", func() {
+		doc := []byte(`{"keys":[{"kty":"oct","k":"AQ","iss":"old","exp":1}]}`)
+		Ω(json.Unmarshal(doc, store)).Should(Succeed())
+		Ω(store.Issuers()).Should(BeEmpty())
+	})
+
+	It("refuses malformed documents", func() {
+		Ω(json.Unmarshal([]byte(`{"keys":[{"kty":"oct","k":"AQ"}]}`), store)).ShouldNot(Succeed())
+		Ω(json.Unmarshal([]byte(`{"keys":[{"kty":"oct","iss":"x"}]}`), store)).ShouldNot(Succeed())
+		Ω(json.Unmarshal([]byte(`{"keys":[{"kty":"oct","k":"AQ","iss_regexp":"("}]}`), store)).ShouldNot(Succeed())
+		Ω(store.Get("moo")).Should(Equal(hmacKey1))
+	})
+})
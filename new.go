@@ -33,7 +33,80 @@ func NewToken(key interface{}, claims Claims) (string, error) {
 	if method == nil {
 		return "", fmt.Errorf("Unsupported key type %T", key)
 	}
+	return signToken(method, key, claims, nil)
+}
+
+// NewTokenWithAlgorithm is like NewToken, but signs the token using a specific
+// JWA algorithm instead of choosing one based on the key's type.
+//
+// The algorithm must be compatible with the key: HS256/384/512 require an
+// HMAC key; RS256/384/512 and PS256/384/512 require an RSA private key; and
+// ES256/384/512 require an ECDSA private key whose curve is P-256, P-384 or
+// P-521 respectively; and EdDSA requires an ed25519.PrivateKey.
+// NewTokenWithAlgorithm returns an error if alg is
+// unknown or incompatible with key.
+//
+// Example token signed using RSA-PSS:
+//      tok, err := jwtauth.NewTokenWithAlgorithm(rsaKey, "PS256", claims)
+func NewTokenWithAlgorithm(key interface{}, alg string, claims Claims) (string, error) {
+	method, err := alg2method(alg, key)
+	if err != nil {
+		return "", err
+	}
+	return signToken(method, key, claims, nil)
+}
+
+// NewEncryptedToken creates a JWT with the specified claims, signs it using
+// the specified issuer key as NewToken does, and then encrypts the signed JWT
+// for its recipient, producing a nested JWE in compact serialization.
+//
+// The key management algorithm is chosen based on the type of recipientKey:
+// RSA-OAEP for an RSA public key, ECDH-ES for an ECDSA public key, and A256KW
+// for a 32-byte []byte or string that is shared with the recipient. The
+// content is encrypted using A256GCM. If recipientKey is a *JWK, its key ID
+// becomes the "kid" header of the token, which helps the recipient choose a
+// decryption key.
+//
+// Example token that only Bob can read:
+//      tok, err := jwtauth.NewEncryptedToken(alicesKey, bobsPublicKey, claims)
+func NewEncryptedToken(signingKey, recipientKey interface{}, claims Claims) (string, error) {
+	key, _ := recipient(recipientKey)
+	alg := key2encryption(key)
+	if alg == "" {
+		return "", fmt.Errorf("Unsupported recipient key type %T", key)
+	}
+	return NewEncryptedTokenWithAlgorithms(signingKey, recipientKey, alg, "A256GCM", claims)
+}
+
+// NewEncryptedTokenWithAlgorithms is like NewEncryptedToken, but encrypts the
+// token using a specific JWA key management algorithm (alg) and content
+// encryption algorithm (enc) instead of choosing them based on the recipient
+// key's type.
+//
+// The supported values of alg are RSA-OAEP, ECDH-ES and A256KW; the supported
+// values of enc are A256GCM and A128CBC-HS256. NewEncryptedTokenWithAlgorithms
+// returns an error if either is unknown or alg is incompatible with
+// recipientKey.
+func NewEncryptedTokenWithAlgorithms(signingKey, recipientKey interface{}, alg, enc string, claims Claims) (string, error) {
+	signed, err := NewToken(signingKey, claims)
+	if err != nil {
+		return "", err
+	}
+	key, kid := recipient(recipientKey)
+	return encryptJWE([]byte(signed), key, alg, enc, kid)
+}
+
+// signToken creates a JWT with the specified claims and signs it. The
+// header parameters, if any, are added to the JWT's header.
+func signToken(method jwt.SigningMethod, key interface{}, claims Claims, header map[string]interface{}) (string, error) {
+	// jwt-go requires HMAC keys to be []byte
+	if s, ok := key.(string); ok {
+		key = []byte(s)
+	}
 	jwt := jwt.NewWithClaims(method, jwt.MapClaims(claims))
+	for k, v := range header {
+		jwt.Header[k] = v
+	}
 	return jwt.SignedString(key)
 }
 
@@ -0,0 +1,225 @@
+package jwtauth_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	jwtpkg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+// tokenAlg returns the "alg" header of a token without verifying it.
+func tokenAlg(token string) string {
+	parsed, _, err := new(jwtpkg.Parser).ParseUnverified(token, jwtpkg.MapClaims{})
+	Ω(err).ShouldNot(HaveOccurred())
+	return parsed.Header["alg"].(string)
+}
+
+var _ = Describe("NewToken()", func() {
+	claims := jwtauth.NewClaims("iss", "alice", "sub", "bob")
+
+	It("chooses an algorithm based on key type", func() {
+		tok, err := jwtauth.NewToken(hmacKey1, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("HS256"))
+
+		tok, err = jwtauth.NewToken(rsaKey1, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("RS256"))
+	})
+
+	It("chooses an ES algorithm based on curve", func() {
+		tok, err := jwtauth.NewToken(ecKey1, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("ES256"))
+
+		tok, err = jwtauth.NewToken(ecKey384a, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("ES384"))
+
+		tok, err = jwtauth.NewToken(ecKey521, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("ES512"))
+	})
+
+	It("chooses EdDSA for Ed25519 keys", func() {
+		tok, err := jwtauth.NewToken(edKey1, claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("EdDSA"))
+	})
+
+	It("accepts string HMAC keys", func() {
+		tok, err := jwtauth.NewToken(string(hmacKey1), claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tokenAlg(tok)).Should(Equal("HS256"))
+	})
+
+	It("rejects unknown key types", func() {
+		_, err := jwtauth.NewToken(42, claims)
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("NewTokenWithAlgorithm()", func() {
+	claims := jwtauth.NewClaims("iss", "alice", "sub", "bob")
+
+	valid := map[string]interface{}{
+		"HS256": hmacKey1,
+		"HS384": hmacKey1,
+		"HS512": hmacKey1,
+		"RS256": rsaKey1,
+		"RS384": rsaKey1,
+		"RS512": rsaKey1,
+		"PS256": rsaKey1,
+		"PS384": rsaKey1,
+		"PS512": rsaKey1,
+		"ES256": ecKey1,
+		"ES384": ecKey384a,
+		"ES512": ecKey521,
+		"EdDSA": edKey1,
+	}
+
+	for alg, key := range valid {
+		alg, key := alg, key
+		It("signs "+alg+" tokens that verify", func() {
+			tok, err := jwtauth.NewTokenWithAlgorithm(key, alg, claims)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(tokenAlg(tok)).Should(Equal(alg))
+
+			_, err = jwtpkg.Parse(tok, func(*jwtpkg.Token) (interface{}, error) {
+				return publicKey(key), nil
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	}
+
+	It("rejects unknown algorithms", func() {
+		_, err := jwtauth.NewTokenWithAlgorithm(hmacKey1, "XY256", claims)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = jwtauth.NewTokenWithAlgorithm(hmacKey1, "none", claims)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("rejects keys of the wrong type", func() {
+		_, err := jwtauth.NewTokenWithAlgorithm(hmacKey1, "RS256", claims)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = jwtauth.NewTokenWithAlgorithm(rsaKey1, "ES256", claims)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = jwtauth.NewTokenWithAlgorithm(ecKey1, "HS256", claims)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = jwtauth.NewTokenWithAlgorithm(rsaKey1, "EdDSA", claims)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("rejects ECDSA keys on the wrong curve", func() {
+		_, err := jwtauth.NewTokenWithAlgorithm(ecKey384a, "ES256", claims)
+		Ω(err).Should(HaveOccurred())
+
+		_, err = jwtauth.NewTokenWithAlgorithm(ecKey1, "ES512", claims)
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+// jweHeader returns the protected header of a JWE without decrypting it.
+func jweHeader(token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	Ω(parts).Should(HaveLen(5))
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	Ω(err).ShouldNot(HaveOccurred())
+	var header map[string]interface{}
+	Ω(json.Unmarshal(raw, &header)).Should(Succeed())
+	return header
+}
+
+var _ = Describe("NewEncryptedToken()", func() {
+	sharedKey := []byte("0123456789abcdef0123456789abcdef")
+	var keys *jwtauth.DecryptionKeystore
+	var stack goa.Handler
+	var claims jwtauth.Claims
+
+	authenticate := func(token string) error {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		setBearerHeader(req, token)
+		return stack(context.Background(), httptest.NewRecorder(), req)
+	}
+
+	BeforeEach(func() {
+		keys = &jwtauth.DecryptionKeystore{}
+		Ω(keys.Add("rsa", rsaKey2)).Should(Succeed())
+		Ω(keys.Add("ec", ecKey2)).Should(Succeed())
+		Ω(keys.Add("kw", sharedKey)).Should(Succeed())
+
+		claims = nil
+		stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			claims = jwtauth.ContextClaims(ctx)
+			return nil
+		}
+		stack = jwtauth.AuthenticateWithOptions(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1},
+			jwtauth.Decryption(keys))(stack)
+	})
+
+	It("chooses an algorithm based on recipient key type", func() {
+		expected := map[string]interface{}{
+			"RSA-OAEP": &rsaKey2.PublicKey,
+			"ECDH-ES":  &ecKey2.PublicKey,
+			"A256KW":   sharedKey,
+		}
+		for alg, key := range expected {
+			tok, err := jwtauth.NewEncryptedToken(hmacKey1, key, jwtauth.NewClaims("iss", "alice", "sub", "bob"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(jweHeader(tok)).Should(HaveKeyWithValue("alg", alg))
+			Ω(jweHeader(tok)).Should(HaveKeyWithValue("enc", "A256GCM"))
+			Ω(authenticate(tok)).Should(Succeed())
+			Ω(claims.Subject()).Should(Equal("bob"))
+		}
+	})
+
+	It("names the recipient's key", func() {
+		jwk := &jwtauth.JWK{Key: &ecKey2.PublicKey, KeyID: "ec"}
+		tok, err := jwtauth.NewEncryptedToken(hmacKey1, jwk, jwtauth.NewClaims("iss", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(jweHeader(tok)).Should(HaveKeyWithValue("kid", "ec"))
+		Ω(authenticate(tok)).Should(Succeed())
+	})
+
+	It("encrypts with specific algorithms", func() {
+		tok, err := jwtauth.NewEncryptedTokenWithAlgorithms(hmacKey1, rsaKey2, "RSA-OAEP", "A128CBC-HS256",
+			jwtauth.NewClaims("iss", "alice", "exp", time.Now().Add(time.Minute).Unix()))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(jweHeader(tok)).Should(HaveKeyWithValue("enc", "A128CBC-HS256"))
+		Ω(authenticate(tok)).Should(Succeed())
+	})
+
+	It("rejects tokens signed by untrusted keys", func() {
+		tok, err := jwtauth.NewEncryptedToken(hmacKey2, sharedKey, jwtauth.NewClaims("iss", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(authenticate(tok)).Should(HaveResponseStatus(401))
+	})
+
+	It("rejects tokens for other recipients", func() {
+		tok, err := jwtauth.NewEncryptedToken(hmacKey1, &ecKey1.PublicKey, jwtauth.NewClaims("iss", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(authenticate(tok)).Should(HaveResponseStatus(401))
+	})
+
+	It("rejects unsuitable algorithms and keys", func() {
+		_, err := jwtauth.NewEncryptedToken(hmacKey1, hmacKey2, jwtauth.NewClaims("iss", "alice"))
+		Ω(err).Should(HaveOccurred())
+		_, err = jwtauth.NewEncryptedTokenWithAlgorithms(hmacKey1, rsaKey2, "ECDH-ES", "A256GCM", jwtauth.NewClaims())
+		Ω(err).Should(HaveOccurred())
+		_, err = jwtauth.NewEncryptedTokenWithAlgorithms(hmacKey1, rsaKey2, "RSA-OAEP", "A192GCM", jwtauth.NewClaims())
+		Ω(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,82 @@
+package jwtauth
+
+type (
+	// options holds the configuration of an authentication middleware.
+	options struct {
+		extraction   ExtractionFunc
+		introspector *Introspector
+		revocation   RevocationStore
+		cache        *TokenCache
+		dpop         *DPoPVerifier
+		mtls         bool
+		mtlsRequired bool
+		decryption   *DecryptionKeystore
+	}
+)
+
+// Extraction is an option that customizes how the middleware finds the
+// token in a request. The default is DefaultExtraction.
+func Extraction(fn ExtractionFunc) Option {
+	return func(o *options) {
+		o.extraction = fn
+	}
+}
+
+// Introspection is an option that accepts opaque (non-JWT) tokens by asking
+// an OAuth2 authorization server whether they are active; see Introspector.
+// Tokens that look like JWTs are still verified using the keystore.
+func Introspection(in *Introspector) Option {
+	return func(o *options) {
+		o.introspector = in
+	}
+}
+
+// Revocation is an option that rejects tokens that have been revoked
+// according to store, even though they have not expired. Such tokens fail
+// with ErrInvalidToken and the detail "Revoked"; the metadata names the
+// claim ("jti", "sub" or "sid") that caused revocation.
+func Revocation(store RevocationStore) Option {
+	return func(o *options) {
+		o.revocation = store
+	}
+}
+
+// Cache is an option that remembers verified JWTs in a TokenCache, so that
+// their signatures need not be verified again until they expire.
+func Cache(cache *TokenCache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// DPoP is an option that verifies DPoP proofs (RFC 9449) for tokens that are
+// bound to a client's key; see DPoPVerifier. The key becomes available to
+// your controllers via ContextDPoPKey().
+func DPoP(v *DPoPVerifier) Option {
+	return func(o *options) {
+		o.dpop = v
+	}
+}
+
+// CertificateBinding is an option that enforces certificate-bound tokens
+// (RFC 8705): if a token's "cnf" claim has an "x5t#S256" member, the request
+// must have been made over mutual TLS using the client certificate with that
+// thumbprint (see CertificateThumbprint). If required is true, tokens that
+// are not certificate-bound are rejected, too.
+//
+// The middleware trusts req.TLS; if TLS is terminated by a proxy, this
+// option cannot be used.
+func CertificateBinding(required bool) Option {
+	return func(o *options) {
+		o.mtls, o.mtlsRequired = true, required
+	}
+}
+
+// Decryption is an option that accepts encrypted tokens (JWE) in addition
+// to signed ones. Encrypted tokens are decrypted using keys, and must
+// contain a signed JWT, which is then verified as usual.
+func Decryption(keys *DecryptionKeystore) Option {
+	return func(o *options) {
+		o.decryption = keys
+	}
+}
@@ -0,0 +1,61 @@
+package jwtauth
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// poller runs a function periodically in a goroutine. Keystores and stores
+// that reload their contents embed it to implement Watch and Close.
+type poller struct {
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// watch starts a goroutine that calls poll every interval until close is
+// called, replacing the goroutine started by a previous call (if any).
+func (p *poller) watch(interval time.Duration, poll func()) {
+	stop := make(chan struct{})
+
+	p.mu.Lock()
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.stop = stop
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				poll()
+			}
+		}
+	}()
+}
+
+// close stops the goroutine started by watch, if any.
+func (p *poller) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// logf logs a message to logger, or to the log package's standard logger if
+// logger is nil.
+func logf(logger *log.Logger, format string, args ...interface{}) {
+	if logger != nil {
+		logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,247 @@
+package jwtauth
+
+import (
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type (
+	// RefreshStore records the families of refresh tokens issued by a
+	// RefreshIssuer. Every refresh token belongs to a family, which begins
+	// when a subject signs in; each refresh replaces the family's current
+	// token with a new one. A store must perform each operation atomically,
+	// so that a refresh token can be used only once even if it is presented
+	// several times concurrently.
+	RefreshStore interface {
+		// CreateFamily records a new family whose current token has the
+		// specified ID. The family may be forgotten after it expires.
+		CreateFamily(family, jti string, expires time.Time) error
+
+		// RotateFamily replaces the current token of a family with a new
+		// one, but only if the current token is old. It reports whether it
+		// did so; it returns false if old is not current, or if the family
+		// is unknown or has been revoked.
+		RotateFamily(family, old, new string, expires time.Time) (bool, error)
+
+		// RevokeFamily forgets a family, so that none of its tokens can be
+		// used again.
+		RevokeFamily(family string) error
+	}
+
+	// RefreshIssuer issues pairs of short-lived access tokens and long-lived
+	// refresh tokens, and exchanges refresh tokens for new pairs. Refresh
+	// tokens are rotated: each can be used only once. If a refresh token is
+	// used twice -- which suggests that it was stolen -- the entire family of
+	// tokens descended from the same sign-in is revoked, so that neither the
+	// thief nor the legitimate client can refresh again.
+	//
+	// Refresh tokens are JWTs with the "typ" header "refresh+jwt", signed by
+	// Key. The authentication middleware never accepts them in place of
+	// access tokens.
+	RefreshIssuer struct {
+		// Issuer is the "iss" of issued tokens.
+		Issuer string
+
+		// Key signs access and refresh tokens.
+		Key interface{}
+
+		// Store records families of refresh tokens.
+		Store RefreshStore
+
+		// AccessTTL is the lifetime of access tokens. If zero,
+		// DefaultAccessTTL is used.
+		AccessTTL time.Duration
+
+		// RefreshTTL is the lifetime of refresh tokens; a client that does
+		// not refresh for this long must sign in again. If zero,
+		// DefaultRefreshTTL is used.
+		RefreshTTL time.Duration
+	}
+
+	// TokenPair is an access token and the refresh token that can be used to
+	// replace it when it expires.
+	TokenPair struct {
+		AccessToken  string
+		RefreshToken string
+
+		// Family identifies the family of the refresh token. Pass it to
+		// RefreshIssuer.Revoke to sign the subject out.
+		Family string
+
+		// ExpiresAt is the time at which the access token expires.
+		ExpiresAt time.Time
+	}
+)
+
+const (
+	// DefaultAccessTTL is the lifetime of access tokens issued by a
+	// RefreshIssuer, unless told otherwise.
+	DefaultAccessTTL = 15 * time.Minute
+
+	// DefaultRefreshTTL is the lifetime of refresh tokens issued by a
+	// RefreshIssuer, unless told otherwise.
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+
+	// refreshTokenType is the "typ" header of refresh tokens.
+	refreshTokenType = "refresh+jwt"
+)
+
+// refreshOnlyClaims are the claims of a refresh token that are not copied
+// to the access tokens that it yields.
+var refreshOnlyClaims = []string{"jti", "fid", "iat", "nbf", "exp"}
+
+// Issue begins a new family of refresh tokens, and returns its first pair
+// of tokens. Both tokens carry the specified claims, plus "iss", "iat" and
+// "exp"; the refresh token also carries "jti" and the family ID, "fid".
+func (ri *RefreshIssuer) Issue(claims Claims) (*TokenPair, error) {
+	family, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	jti, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := ri.Store.CreateFamily(family, jti, now.Add(ri.refreshTTL())); err != nil {
+		return nil, err
+	}
+	return ri.pair(claims, family, jti, now)
+}
+
+// Refresh verifies a refresh token and exchanges it for a new pair of
+// tokens with the same claims. The refresh token cannot be used again; if it
+// has been used before, Refresh revokes its family and returns an error.
+func (ri *RefreshIssuer) Refresh(refreshToken string) (*TokenPair, error) {
+	claims, err := ri.verify(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	family, old := claims.String("fid"), claims.String("jti")
+	if family == "" || old == "" {
+		return nil, ErrInvalidToken("Refresh token has no family")
+	}
+
+	jti, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rotated, err := ri.Store.RotateFamily(family, old, jti, now.Add(ri.refreshTTL()))
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// the token was already used, or its family was revoked; either
+		// way, nobody may refresh this family again
+		if err := ri.Store.RevokeFamily(family); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidToken("Refresh token is no longer valid", "fid", family)
+	}
+
+	for _, name := range refreshOnlyClaims {
+		delete(claims, name)
+	}
+	return ri.pair(claims, family, jti, now)
+}
+
+// Revoke revokes a family of refresh tokens, e.g. when its subject signs
+// out. Access tokens that were already issued remain valid until they
+// expire.
+func (ri *RefreshIssuer) Revoke(family string) error {
+	return ri.Store.RevokeFamily(family)
+}
+
+// pair mints an access token and a refresh token.
+func (ri *RefreshIssuer) pair(claims Claims, family, jti string, now time.Time) (*TokenPair, error) {
+	method := key2method(ri.Key)
+	if method == nil {
+		return nil, fmt.Errorf("Unsupported key type %T", ri.Key)
+	}
+
+	expires := now.Add(ri.accessTTL())
+	access := make(Claims, len(claims)+3)
+	for k, v := range claims {
+		access[k] = v
+	}
+	access["iss"] = ri.Issuer
+	access["iat"] = now.Unix()
+	access["exp"] = expires.Unix()
+	accessToken, err := signToken(method, ri.Key, access, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := make(Claims, len(access)+2)
+	for k, v := range access {
+		refresh[k] = v
+	}
+	refresh["exp"] = now.Add(ri.refreshTTL()).Unix()
+	refresh["jti"] = jti
+	refresh["fid"] = family
+	refreshToken, err := signToken(method, ri.Key, refresh, map[string]interface{}{"typ": refreshTokenType})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Family:       family,
+		ExpiresAt:    time.Unix(expires.Unix(), 0),
+	}, nil
+}
+
+// verify verifies the signature, issuer and type of a refresh token, and
+// returns its claims.
+func (ri *RefreshIssuer) verify(refreshToken string) (Claims, error) {
+	key, err := trustableKey(ri.Key)
+	if err != nil {
+		return nil, err
+	}
+	token, _, err := parseToken(&SimpleKeystore{Key: key}, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if !isRefreshToken(token) {
+		return nil, ErrInvalidToken("Not a refresh token")
+	}
+	claims := Claims(token.Claims.(jwt.MapClaims))
+	if claims.Issuer() != ri.Issuer {
+		return nil, ErrInvalidToken("Untrusted", "issuer", claims.Issuer())
+	}
+	return claims, nil
+}
+
+func (ri *RefreshIssuer) accessTTL() time.Duration {
+	if ri.AccessTTL == 0 {
+		return DefaultAccessTTL
+	}
+	return ri.AccessTTL
+}
+
+func (ri *RefreshIssuer) refreshTTL() time.Duration {
+	if ri.RefreshTTL == 0 {
+		return DefaultRefreshTTL
+	}
+	return ri.RefreshTTL
+}
+
+// isRefreshToken determines whether a verified JWT is a refresh token.
+func isRefreshToken(token *jwt.Token) bool {
+	typ, _ := token.Header["typ"].(string)
+	return typ == refreshTokenType
+}
+
+// randomID returns a random, URL-safe identifier.
+func randomID() (string, error) {
+	b, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	return encodeJWKBytes(b), nil
+}
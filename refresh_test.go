@@ -0,0 +1,121 @@
+package jwtauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("RefreshIssuer", func() {
+	var issuer *jwtauth.RefreshIssuer
+	var stack func(token string) error
+	var claims jwtauth.Claims
+
+	BeforeEach(func() {
+		issuer = &jwtauth.RefreshIssuer{
+			Issuer:    "login",
+			Key:       rsaKey1,
+			Store:     &jwtauth.MemoryRefreshStore{},
+			AccessTTL: time.Minute,
+		}
+
+		store := &jwtauth.NamedKeystore{}
+		Ω(store.Trust("login", rsaKey1.Public())).Should(Succeed())
+		middleware := jwtauth.Authenticate(commonScheme, store)(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				claims = jwtauth.ContextClaims(ctx)
+				return nil
+			})
+		stack = func(token string) error {
+			req, _ := http.NewRequest("GET", "http://example.com/", nil)
+			setBearerHeader(req, token)
+			return middleware(context.Background(), httptest.NewRecorder(), req)
+		}
+	})
+
+	It("issues token pairs", func() {
+		pair, err := issuer.Issue(jwtauth.NewClaims("sub", "alice", "scopes", []string{"read"}))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(pair.Family).ShouldNot(BeEmpty())
+		Ω(pair.ExpiresAt).Should(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+		Ω(stack(pair.AccessToken)).Should(Succeed())
+		Ω(claims.Issuer()).Should(Equal("login"))
+		Ω(claims.Subject()).Should(Equal("alice"))
+		Ω(claims.Strings("scopes")).Should(Equal([]string{"read"}))
+		Ω(claims).ShouldNot(HaveKey("fid"))
+	})
+
+	It("does not accept refresh tokens for authentication", func() {
+		pair, err := issuer.Issue(jwtauth.NewClaims("sub", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stack(pair.RefreshToken)).Should(HaveResponseStatus(401))
+	})
+
+	It("rotates refresh tokens", func() {
+		first, err := issuer.Issue(jwtauth.NewClaims("sub", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		second, err := issuer.Refresh(first.RefreshToken)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(second.Family).Should(Equal(first.Family))
+		Ω(second.RefreshToken).ShouldNot(Equal(first.RefreshToken))
+
+		Ω(stack(second.AccessToken)).Should(Succeed())
+		Ω(claims.Subject()).Should(Equal("alice"))
+		Ω(claims).ShouldNot(HaveKey("jti"))
+
+		third, err := issuer.Refresh(second.RefreshToken)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(third.Family).Should(Equal(first.Family))
+	})
+
+	It("revokes the family when a refresh token is reused", func() {
+		first, err := issuer.Issue(jwtauth.NewClaims("sub", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		second, err := issuer.Refresh(first.RefreshToken)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = issuer.Refresh(first.RefreshToken)
+		Ω(err).Should(HaveResponseStatus(401))
+		_, err = issuer.Refresh(second.RefreshToken)
+		Ω(err).Should(HaveResponseStatus(401))
+	})
+
+	It("keeps other families", func() {
+		alice, err := issuer.Issue(jwtauth.NewClaims("sub", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		bob, err := issuer.Issue(jwtauth.NewClaims("sub", "bob"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(issuer.Revoke(alice.Family)).Should(Succeed())
+
+		_, err = issuer.Refresh(alice.RefreshToken)
+		Ω(err).Should(HaveOccurred())
+		_, err = issuer.Refresh(bob.RefreshToken)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("rejects access tokens and foreign tokens", func() {
+		pair, err := issuer.Issue(jwtauth.NewClaims("sub", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = issuer.Refresh(pair.AccessToken)
+		Ω(err).Should(HaveOccurred())
+
+		other := &jwtauth.RefreshIssuer{Issuer: "login", Key: rsaKey2, Store: issuer.Store}
+		pair, err = other.Issue(jwtauth.NewClaims("sub", "alice"))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = issuer.Refresh(pair.RefreshToken)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("rejects tokens whose alg does not suit the key", func() {
+		token := makeToken("login", "alice", hmacKey1)
+		var err error
+		Ω(func() { _, err = issuer.Refresh(token) }).ShouldNot(Panic())
+		Ω(err).Should(HaveResponseStatus(401))
+	})
+})
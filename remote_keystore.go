@@ -0,0 +1,374 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RemoteKeystore is a concurrency-safe Keystore that trusts the keys
+	// published by a single issuer as a JWK Set at a URL, such as the
+	// "jwks_uri" of an OpenID Connect provider. Tokens from other issuers are
+	// not trusted.
+	//
+	// Call Refresh to fetch the key set again, or Watch to fetch it
+	// periodically. If the key set cannot be fetched, the problem is logged
+	// and the keys that were trusted remain trusted. Subscribers (see
+	// Subscribe) are notified when the issuer becomes trusted, when its keys
+	// change, and when a refresh fails.
+	//
+	// A RemoteKeystore can restrict the issuer to a list of signing
+	// algorithms. Keys that the key set marks with another algorithm are
+	// ignored, and tokens signed with other algorithms are rejected.
+	//
+	// Only RSA, EC and OKP public keys are accepted; a key set that contains
+	// a symmetric key is rejected, since its secret would be public.
+	//
+	// Trust() and RevokeTrust() have no effect, although Trust() returns an
+	// error; the key set is the single source of truth.
+	RemoteKeystore struct {
+		// Issuer is the only issuer whose keys are trusted.
+		Issuer string
+
+		// Client fetches documents. If nil, a client with a timeout of
+		// DefaultRemoteTimeout is used.
+		Client *http.Client
+
+		// ErrorLog receives messages about documents that could not be
+		// fetched. If nil, messages are logged using the log package's
+		// standard logger.
+		ErrorLog *log.Logger
+
+		reload     sync.Mutex
+		mu         sync.RWMutex
+		discovery  string
+		jwksURL    string
+		algorithms []string
+		etag       string
+		fetched    *JWKSet
+		keys       *JWKSet
+		events     observers
+		poller
+	}
+
+	// oidcConfiguration is the subset of OpenID Connect provider metadata
+	// that RemoteKeystore uses.
+	oidcConfiguration struct {
+		Issuer     string   `json:"issuer"`
+		JWKSURI    string   `json:"jwks_uri"`
+		Algorithms []string `json:"id_token_signing_alg_values_supported"`
+	}
+)
+
+const (
+	// DefaultRemoteTimeout bounds the time that a RemoteKeystore spends
+	// fetching its documents during each refresh.
+	DefaultRemoteTimeout = 30 * time.Second
+
+	// maxRemoteDocumentSize is the size of the largest discovery document or
+	// key set that a RemoteKeystore accepts.
+	maxRemoteDocumentSize = 1 << 20
+
+	// oidcDiscoveryPath is the path of the OpenID Connect discovery
+	// document, relative to the issuer.
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+)
+
+// defaultRemoteClient fetches documents for a RemoteKeystore that has no
+// Client. Unlike http.DefaultClient, it gives up on unresponsive servers.
+var defaultRemoteClient = &http.Client{Timeout: DefaultRemoteTimeout}
+
+// NewRemoteKeystore creates a RemoteKeystore that trusts the key set at
+// jwksURL for issuer, restricted to the specified signing algorithms (if
+// any). It fetches the key set and (if interval is positive) starts
+// refreshing it periodically. It returns an error if the key set cannot be
+// fetched.
+func NewRemoteKeystore(issuer, jwksURL string, algorithms []string, interval time.Duration) (*RemoteKeystore, error) {
+	rk := &RemoteKeystore{Issuer: issuer, jwksURL: jwksURL, algorithms: algorithms}
+	if err := rk.start(interval); err != nil {
+		return nil, err
+	}
+	return rk, nil
+}
+
+// NewOIDCKeystore creates a RemoteKeystore for an OpenID Connect provider,
+// given only its issuer URL. It fetches the provider's discovery document,
+// checks that the document names the same issuer, and then trusts the key
+// set at the document's "jwks_uri", restricted to the document's
+// "id_token_signing_alg_values_supported". If interval is positive, both
+// discovery and the key set are refreshed periodically.
+func NewOIDCKeystore(issuer string, interval time.Duration) (*RemoteKeystore, error) {
+	rk := &RemoteKeystore{
+		Issuer:    issuer,
+		discovery: strings.TrimSuffix(issuer, "/") + oidcDiscoveryPath,
+	}
+	if err := rk.start(interval); err != nil {
+		return nil, err
+	}
+	return rk, nil
+}
+
+// start refreshes the keystore for the first time, and then watches it.
+func (rk *RemoteKeystore) start(interval time.Duration) error {
+	if err := rk.Refresh(); err != nil {
+		return err
+	}
+	if interval > 0 {
+		rk.Watch(interval)
+	}
+	return nil
+}
+
+// Trust implements jwtauth.Keystore#Trust
+func (rk *RemoteKeystore) Trust(issuer string, key interface{}) error {
+	return fmt.Errorf("cannot trust additional keys; publish them at %s instead", rk.JWKSURL())
+}
+
+// RevokeTrust implements jwtauth.Keystore#RevokeTrust
+func (rk *RemoteKeystore) RevokeTrust(issuer string) {
+}
+
+// Subscribe registers a function to be notified of changes to the trusted
+// keys and of failures to refresh them, and returns a function that cancels
+// the subscription. Events are delivered in the goroutine that called
+// Refresh.
+func (rk *RemoteKeystore) Subscribe(fn EventFunc) (unsubscribe func()) {
+	return rk.events.subscribe(fn)
+}
+
+// Get implements jwtauth.Keystore#Get
+func (rk *RemoteKeystore) Get(issuer string) interface{} {
+	if issuer != rk.Issuer {
+		return nil
+	}
+
+	rk.mu.RLock()
+	defer rk.mu.RUnlock()
+
+	if rk.keys != nil {
+		return rk.keys
+	}
+	return nil
+}
+
+// JWKSURL returns the URL of the trusted key set.
+func (rk *RemoteKeystore) JWKSURL() string {
+	rk.mu.RLock()
+	defer rk.mu.RUnlock()
+	return rk.jwksURL
+}
+
+// Algorithms returns the signing algorithms that the issuer may use, or nil
+// if it may use any.
+func (rk *RemoteKeystore) Algorithms() []string {
+	rk.mu.RLock()
+	defer rk.mu.RUnlock()
+	return rk.algorithms
+}
+
+// Refresh repeats discovery (for a keystore created by NewOIDCKeystore),
+// fetches the key set, and updates the trusted keys to match. It returns an
+// error if either document cannot be fetched, in which case the trusted keys
+// are left unchanged. Each refresh gives up after DefaultRemoteTimeout.
+func (rk *RemoteKeystore) Refresh() error {
+	rk.reload.Lock()
+	defer rk.reload.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRemoteTimeout)
+	defer cancel()
+
+	err := rk.refresh(ctx)
+	if err != nil {
+		rk.events.emit(KeystoreEvent{Type: EventRefreshFailed, Issuer: rk.Issuer, Err: err})
+	}
+	return err
+}
+
+func (rk *RemoteKeystore) refresh(ctx context.Context) error {
+	rk.mu.RLock()
+	jwksURL, algorithms, etag, fetched, old := rk.jwksURL, rk.algorithms, rk.etag, rk.fetched, rk.keys
+	rk.mu.RUnlock()
+
+	if rk.discovery != "" {
+		config, err := rk.discover(ctx)
+		if err != nil {
+			return err
+		}
+		if config.JWKSURI != jwksURL {
+			// a different key set; don't trust a cached copy of the old one
+			etag, fetched = "", nil
+		}
+		jwksURL, algorithms = config.JWKSURI, config.Algorithms
+	}
+
+	body, newETag, err := rk.fetch(ctx, jwksURL, etag)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		set, err := ParseJWKSet(body)
+		if err != nil {
+			return fmt.Errorf("invalid key set at %s: %s", jwksURL, err)
+		}
+		trustable, err := trustableKey(set)
+		if err != nil {
+			return fmt.Errorf("invalid key set at %s: %s", jwksURL, err)
+		}
+		if err := checkPublicKeys(trustable.(*JWKSet)); err != nil {
+			return fmt.Errorf("invalid key set at %s: %s", jwksURL, err)
+		}
+		fetched, etag = trustable.(*JWKSet), newETag
+	}
+
+	keys := restrictAlgorithms(fetched, algorithms)
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("key set at %s has no keys for algorithms %v", jwksURL, algorithms)
+	}
+
+	rk.mu.Lock()
+	rk.jwksURL, rk.algorithms = jwksURL, algorithms
+	rk.etag, rk.fetched, rk.keys = etag, fetched, keys
+	rk.mu.Unlock()
+
+	oldKeys := map[string]interface{}{}
+	if old != nil {
+		oldKeys[rk.Issuer] = old
+	}
+	for _, ev := range diffKeys(oldKeys, map[string]interface{}{rk.Issuer: keys}) {
+		rk.events.emit(ev)
+	}
+	return nil
+}
+
+// discover fetches and checks the OpenID Connect discovery document.
+func (rk *RemoteKeystore) discover(ctx context.Context) (*oidcConfiguration, error) {
+	body, _, err := rk.fetch(ctx, rk.discovery, "")
+	if err != nil {
+		return nil, err
+	}
+	config := &oidcConfiguration{}
+	if err := json.Unmarshal(body, config); err != nil {
+		return nil, fmt.Errorf("invalid discovery document at %s: %s", rk.discovery, err)
+	}
+	if config.Issuer != rk.Issuer {
+		return nil, fmt.Errorf("discovery document at %s is for issuer '%s', not '%s'", rk.discovery, config.Issuer, rk.Issuer)
+	}
+	if config.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document at %s has no jwks_uri", rk.discovery)
+	}
+	return config, nil
+}
+
+// fetch gets a document. If the document has not changed since it had the
+// specified ETag, fetch returns a nil body. Documents larger than
+// maxRemoteDocumentSize are rejected.
+func (rk *RemoteKeystore) fetch(ctx context.Context, url, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	client := rk.Client
+	if client == nil {
+		client = defaultRemoteClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, etag, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("cannot fetch %s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteDocumentSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxRemoteDocumentSize {
+		return nil, "", fmt.Errorf("cannot fetch %s: document is larger than %d bytes", url, maxRemoteDocumentSize)
+	}
+	return body, resp.Header.Get("ETag"), nil
+}
+
+// Watch starts refreshing the keystore every interval, until Close is
+// called. Errors are logged. Calling Watch again replaces the previous
+// schedule.
+func (rk *RemoteKeystore) Watch(interval time.Duration) {
+	rk.poller.watch(interval, func() {
+		if err := rk.Refresh(); err != nil {
+			logf(rk.ErrorLog, "jwtauth: cannot refresh keys for issuer '%s': %s", rk.Issuer, err)
+		}
+	})
+}
+
+// Close stops refreshing the keystore. The keys that were trusted remain
+// trusted.
+func (rk *RemoteKeystore) Close() {
+	rk.poller.close()
+}
+
+// checkPublicKeys ensures that a published key set contains only asymmetric
+// keys. A symmetric ("oct") key that anybody can fetch is no secret, so
+// trusting it would let anybody forge tokens.
+func checkPublicKeys(set *JWKSet) error {
+	for _, jwk := range set.Keys {
+		switch jwk.Key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return fmt.Errorf("key %q is not an RSA, EC or OKP public key", jwk.KeyID)
+		}
+	}
+	return nil
+}
+
+// restrictAlgorithms returns the keys of a set that may be used to verify
+// signatures made with the specified algorithms. Keys meant for encryption
+// are dropped. A key that names its algorithm is kept if that algorithm is
+// allowed; a key that does not is listed once for every allowed algorithm
+// that suits it, so that tokens signed with any other algorithm find no
+// candidate key. If algorithms is empty, every signing key is kept.
+func restrictAlgorithms(set *JWKSet, algorithms []string) *JWKSet {
+	restricted := &JWKSet{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if len(algorithms) == 0 {
+			restricted.Keys = append(restricted.Keys, jwk)
+		}
+		for _, alg := range algorithms {
+			if jwk.Algorithm != "" && jwk.Algorithm != alg {
+				continue
+			}
+			if _, err := alg2method(alg, jwk.Key); err != nil {
+				continue
+			}
+			restricted.Keys = append(restricted.Keys, &JWK{
+				Key:       jwk.Key,
+				KeyID:     jwk.KeyID,
+				Algorithm: alg,
+				Use:       jwk.Use,
+			})
+		}
+	}
+	return restricted
+}
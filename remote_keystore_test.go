@@ -0,0 +1,197 @@
+package jwtauth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+var _ = Describe("RemoteKeystore", func() {
+	var server *httptest.Server
+	var keys *jwtauth.SigningKeySet
+	var config map[string]interface{}
+	var stores []*jwtauth.RemoteKeystore
+	var mu sync.Mutex
+
+	// track closes a new store after the test, so that it stops refreshing
+	// before the server goes away.
+	track := func(store *jwtauth.RemoteKeystore, err error) (*jwtauth.RemoteKeystore, error) {
+		if store != nil {
+			stores = append(stores, store)
+		}
+		return store, err
+	}
+
+	// setConfig changes a member of the discovery document.
+	setConfig := func(name string, value interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		config[name] = value
+	}
+
+	authenticate := func(store jwtauth.Keystore, token string) error {
+		stack := jwtauth.Authenticate(commonScheme, store)(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return nil
+			})
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		setBearerHeader(req, token)
+		return stack(context.Background(), httptest.NewRecorder(), req)
+	}
+
+	BeforeEach(func() {
+		var err error
+		keys, err = jwtauth.NewSigningKeySet("one", rsaKey1)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		mux := http.NewServeMux()
+		mux.Handle(jwtauth.JWKSPath, keys)
+		mux.HandleFunc("/oct.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"keys":[{"kty":"oct","kid":"s","k":"c2VjcmV0"}]}`))
+		})
+		mux.HandleFunc("/huge.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"keys":[],"padding":"`))
+			w.Write(bytes.Repeat([]byte("x"), 2<<20))
+			w.Write([]byte(`"}`))
+		})
+		mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			json.NewEncoder(w).Encode(config)
+		})
+		server = httptest.NewServer(mux)
+		config = map[string]interface{}{
+			"issuer":                                server.URL,
+			"jwks_uri":                              server.URL + jwtauth.JWKSPath,
+			"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+		}
+	})
+
+	AfterEach(func() {
+		for _, store := range stores {
+			store.Close()
+		}
+		stores = nil
+		server.Close()
+	})
+
+	It("trusts the issuer's published keys", func() {
+		store, err := track(jwtauth.NewRemoteKeystore("login", server.URL+jwtauth.JWKSPath, nil, 0))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Get("other")).Should(BeNil())
+
+		tok, err := keys.NewToken(jwtauth.NewClaims("iss", "login"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(authenticate(store, tok)).Should(Succeed())
+
+		tok, err = keys.NewToken(jwtauth.NewClaims("iss", "other"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(authenticate(store, tok)).Should(HaveResponseStatus(401))
+	})
+
+	It("picks up rotated keys", func() {
+		store, err := track(jwtauth.NewRemoteKeystore("login", server.URL+jwtauth.JWKSPath, nil, 0))
+		Ω(err).ShouldNot(HaveOccurred())
+		var events []jwtauth.KeystoreEvent
+		store.Subscribe(func(ev jwtauth.KeystoreEvent) {
+			events = append(events, ev)
+		})
+
+		Ω(store.Refresh()).Should(Succeed())
+		Ω(events).Should(BeEmpty())
+
+		Ω(keys.Announce("two", ecKey1)).Should(Succeed())
+		Ω(keys.Rotate()).Should(Succeed())
+		Ω(store.Refresh()).Should(Succeed())
+		Ω(events).Should(HaveLen(1))
+		Ω(events[0].Type).Should(Equal(jwtauth.EventRotated))
+
+		tok, err := keys.NewToken(jwtauth.NewClaims("iss", "login"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(authenticate(store, tok)).Should(Succeed())
+	})
+
+	It("keeps its keys when a refresh fails", func() {
+		store, err := track(jwtauth.NewRemoteKeystore("login", server.URL+jwtauth.JWKSPath, nil, 0))
+		Ω(err).ShouldNot(HaveOccurred())
+		var events []jwtauth.KeystoreEvent
+		store.Subscribe(func(ev jwtauth.KeystoreEvent) {
+			events = append(events, ev)
+		})
+
+		server.Close()
+		Ω(store.Refresh()).ShouldNot(Succeed())
+		Ω(store.Get("login")).ShouldNot(BeNil())
+		Ω(events).Should(HaveLen(1))
+		Ω(events[0].Type).Should(Equal(jwtauth.EventRefreshFailed))
+	})
+
+	It("refuses symmetric keys", func() {
+		_, err := jwtauth.NewRemoteKeystore("login", server.URL+"/oct.json", nil, 0)
+		Ω(err).Should(MatchError(ContainSubstring("not an RSA, EC or OKP public key")))
+	})
+
+	It("refuses oversized documents", func() {
+		_, err := jwtauth.NewRemoteKeystore("login", server.URL+"/huge.json", nil, 0)
+		Ω(err).Should(MatchError(ContainSubstring("larger than")))
+	})
+
+	Context("NewOIDCKeystore()", func() {
+		It("discovers the key set", func() {
+			store, err := track(jwtauth.NewOIDCKeystore(server.URL, 0))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(store.JWKSURL()).Should(Equal(server.URL + jwtauth.JWKSPath))
+			Ω(store.Algorithms()).Should(Equal([]string{"RS256", "ES256"}))
+
+			tok, err := keys.NewToken(jwtauth.NewClaims("iss", server.URL))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(authenticate(store, tok)).Should(Succeed())
+		})
+
+		It("checks the issuer", func() {
+			setConfig("issuer", "https://impostor.example.com")
+			_, err := jwtauth.NewOIDCKeystore(server.URL, 0)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("impostor"))
+		})
+
+		It("only allows the advertised algorithms", func() {
+			Ω(keys.Announce("two", ecKey1)).Should(Succeed())
+			setConfig("id_token_signing_alg_values_supported", []string{"ES256"})
+			store, err := track(jwtauth.NewOIDCKeystore(server.URL, 0))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			tok, err := keys.NewToken(jwtauth.NewClaims("iss", server.URL))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(authenticate(store, tok)).Should(HaveResponseStatus(401))
+
+			Ω(keys.Rotate()).Should(Succeed())
+			tok, err = keys.NewToken(jwtauth.NewClaims("iss", server.URL))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(authenticate(store, tok)).Should(Succeed())
+		})
+
+		It("repeats discovery periodically", func() {
+			Ω(keys.Announce("two", ecKey1)).Should(Succeed())
+			store, err := track(jwtauth.NewOIDCKeystore(server.URL, 10*time.Millisecond))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer store.Close()
+
+			tok, err := keys.NewToken(jwtauth.NewClaims("iss", server.URL))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(authenticate(store, tok)).Should(Succeed())
+
+			setConfig("id_token_signing_alg_values_supported", []string{"ES256"})
+			Eventually(store.Algorithms).Should(Equal([]string{"ES256"}))
+			Ω(authenticate(store, tok)).Should(HaveResponseStatus(401))
+		})
+	})
+})
@@ -0,0 +1,187 @@
+package jwtauth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+type (
+	// TokenCache remembers JWTs whose signatures have been verified, so that
+	// a client that presents the same token many times does not incur the
+	// cost of verifying it every time. It is a bounded LRU cache keyed by a
+	// hash of the token; entries expire with the token. Tokens without an
+	// "exp" claim are never cached.
+	//
+	// To use a TokenCache, pass it to AuthenticateWithOptions using the Cache
+	// option. If the middleware's keystore supports subscriptions (as do
+	// NamedKeystore, DirectoryKeystore and CompositeKeystore), the cache is
+	// emptied whenever the keystore revokes, rotates or expires a key;
+	// otherwise, tokens remain cached until they expire even if their issuer
+	// is no longer trusted.
+	//
+	// A TokenCache must not be shared by middlewares that use different
+	// keystores; it follows only the keystore of the middleware that was
+	// created last. Call Close to stop following it. A TokenCache is safe for
+	// concurrent use.
+	TokenCache struct {
+		size       int
+		mu         sync.Mutex
+		lru        *list.List
+		entries    map[[sha256.Size]byte]*list.Element
+		generation uint64
+		unwatch    func()
+	}
+
+	// cachedToken is a TokenCache entry.
+	cachedToken struct {
+		hash    [sha256.Size]byte
+		claims  Claims
+		tenant  string
+		expires time.Time
+	}
+)
+
+// NewTokenCache creates a TokenCache that holds at most size tokens.
+func NewTokenCache(size int) *TokenCache {
+	return &TokenCache{
+		size:    size,
+		lru:     list.New(),
+		entries: map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// Len returns the number of tokens in the cache.
+func (tc *TokenCache) Len() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.lru.Len()
+}
+
+// Purge removes every token from the cache. Tokens whose verification was
+// under way are not cached when it completes, since their key may be the
+// reason for the purge.
+func (tc *TokenCache) Purge() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.lru.Init()
+	tc.entries = map[[sha256.Size]byte]*list.Element{}
+	tc.generation++
+}
+
+// Close stops following the keystore's events. The cache remains usable,
+// but is no longer emptied when the keystore stops trusting a key.
+func (tc *TokenCache) Close() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.unwatch != nil {
+		tc.unwatch()
+		tc.unwatch = nil
+	}
+}
+
+// snapshot returns the cache's generation, which changes whenever the cache
+// is purged. Call it before verifying a token, and pass the result to add.
+func (tc *TokenCache) snapshot() uint64 {
+	if tc == nil {
+		return 0
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.generation
+}
+
+// get returns the claims and tenant of a verified token, if it is cached and
+// has not expired. A nil cache caches nothing.
+func (tc *TokenCache) get(rawToken string) (Claims, string, bool) {
+	if tc == nil {
+		return nil, "", false
+	}
+	hash := sha256.Sum256([]byte(rawToken))
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	elem, ok := tc.entries[hash]
+	if !ok {
+		return nil, "", false
+	}
+	entry := elem.Value.(*cachedToken)
+	if !time.Now().Before(entry.expires) {
+		tc.lru.Remove(elem)
+		delete(tc.entries, hash)
+		return nil, "", false
+	}
+	tc.lru.MoveToFront(elem)
+	return copyClaims(entry.claims), entry.tenant, true
+}
+
+// add caches a verified token, evicting the least recently used token if
+// the cache is full. The token is not cached if the cache was purged since
+// the specified generation, i.e. while the token was being verified.
+func (tc *TokenCache) add(rawToken string, claims Claims, tenant string, generation uint64) {
+	if tc == nil || tc.size <= 0 {
+		return
+	}
+	if _, ok := claims["exp"]; !ok {
+		return
+	}
+	tc.put(rawToken, claims, tenant, claims.ExpiresAt(), generation)
+}
+
+// put caches the claims and tenant of a token until the specified time, as
+// add does, regardless of the token's own "exp".
+func (tc *TokenCache) put(rawToken string, claims Claims, tenant string, expires time.Time, generation uint64) {
+	if tc == nil || tc.size <= 0 {
+		return
+	}
+	entry := &cachedToken{
+		hash:    sha256.Sum256([]byte(rawToken)),
+		claims:  copyClaims(claims),
+		tenant:  tenant,
+		expires: expires,
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.generation != generation {
+		return
+	}
+	if elem, ok := tc.entries[entry.hash]; ok {
+		elem.Value = entry
+		tc.lru.MoveToFront(elem)
+		return
+	}
+	for tc.lru.Len() >= tc.size {
+		oldest := tc.lru.Back()
+		tc.lru.Remove(oldest)
+		delete(tc.entries, oldest.Value.(*cachedToken).hash)
+	}
+	tc.entries[entry.hash] = tc.lru.PushFront(entry)
+}
+
+// watch empties the cache whenever a keystore stops trusting a key, and
+// cancels any subscription to the keystore that it watched before. Issuer
+// patterns can match many issuers, so we don't try to be selective.
+func (tc *TokenCache) watch(store Keystore) {
+	var unwatch func()
+	if s, ok := store.(subscriber); ok {
+		unwatch = s.Subscribe(func(ev KeystoreEvent) {
+			switch ev.Type {
+			case EventRevoked, EventRotated, EventExpired:
+				tc.Purge()
+			}
+		})
+	}
+
+	tc.mu.Lock()
+	previous := tc.unwatch
+	tc.unwatch = unwatch
+	tc.mu.Unlock()
+
+	if previous != nil {
+		previous()
+	}
+}
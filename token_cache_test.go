@@ -0,0 +1,161 @@
+package jwtauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/goadesign/goa"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+// countingKeystore counts the number of times that a key is looked up, i.e.
+// the number of tokens that are verified, and the number of subscribers that
+// it has.
+type countingKeystore struct {
+	jwtauth.NamedKeystore
+	gets        int32
+	subscribers int32
+	onMatch     func()
+}
+
+func (ck *countingKeystore) Match(issuer string) (interface{}, string) {
+	atomic.AddInt32(&ck.gets, 1)
+	if ck.onMatch != nil {
+		ck.onMatch()
+	}
+	return ck.NamedKeystore.Match(issuer)
+}
+
+func (ck *countingKeystore) Subscribe(fn jwtauth.EventFunc) func() {
+	atomic.AddInt32(&ck.subscribers, 1)
+	unsubscribe := ck.NamedKeystore.Subscribe(fn)
+	return func() {
+		atomic.AddInt32(&ck.subscribers, -1)
+		unsubscribe()
+	}
+}
+
+var _ = Describe("TokenCache", func() {
+	var store *countingKeystore
+	var cache *jwtauth.TokenCache
+	var stack goa.Handler
+	var req *http.Request
+
+	authenticate := func(token string) error {
+		setBearerHeader(req, token)
+		return stack(context.Background(), httptest.NewRecorder(), req)
+	}
+
+	BeforeEach(func() {
+		store = &countingKeystore{}
+		Ω(store.Trust("alice", rsaKey1)).Should(Succeed())
+		cache = jwtauth.NewTokenCache(2)
+
+		req, _ = http.NewRequest("GET", "http://example.com/", nil)
+		stack = func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			Ω(jwtauth.ContextClaims(ctx).Subject()).ShouldNot(BeEmpty())
+			return nil
+		}
+		stack = jwtauth.AuthenticateWithOptions(commonScheme, store, jwtauth.Cache(cache))(stack)
+	})
+
+	It("verifies each token once", func() {
+		token := makeToken("alice", "bob", rsaKey1)
+		for i := 0; i < 3; i++ {
+			Ω(authenticate(token)).Should(Succeed())
+		}
+		Ω(store.gets).Should(Equal(int32(1)))
+		Ω(cache.Len()).Should(Equal(1))
+	})
+
+	It("does not cache invalid tokens", func() {
+		token := makeToken("alice", "bob", rsaKey2)
+		Ω(authenticate(token)).Should(HaveResponseStatus(401))
+		Ω(authenticate(token)).Should(HaveResponseStatus(401))
+		Ω(cache.Len()).Should(Equal(0))
+	})
+
+	It("does not cache tokens that never expire", func() {
+		token, err := jwtauth.NewToken(rsaKey1, jwtauth.NewClaims("iss", "alice", "sub", "bob"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(authenticate(token)).Should(Succeed())
+		Ω(cache.Len()).Should(Equal(0))
+	})
+
+	It("evicts the least recently used token", func() {
+		bob, carol, dave := makeToken("alice", "bob", rsaKey1), makeToken("alice", "carol", rsaKey1), makeToken("alice", "dave", rsaKey1)
+		Ω(authenticate(bob)).Should(Succeed())
+		Ω(authenticate(carol)).Should(Succeed())
+		Ω(authenticate(bob)).Should(Succeed())
+		Ω(authenticate(dave)).Should(Succeed())
+		Ω(store.gets).Should(Equal(int32(3)))
+
+		Ω(authenticate(bob)).Should(Succeed())
+		Ω(store.gets).Should(Equal(int32(3)))
+		Ω(authenticate(carol)).Should(Succeed())
+		Ω(store.gets).Should(Equal(int32(4)))
+	})
+
+	It("is emptied when the keystore revokes trust", func() {
+		token := makeToken("alice", "bob", rsaKey1)
+		Ω(authenticate(token)).Should(Succeed())
+
+		store.RevokeTrust("alice")
+
+		Ω(cache.Len()).Should(Equal(0))
+		Ω(authenticate(token)).Should(HaveResponseStatus(401))
+	})
+
+	It("does not cache tokens that were verified during a purge", func() {
+		store.onMatch = cache.Purge
+		Ω(authenticate(makeToken("alice", "bob", rsaKey1))).Should(Succeed())
+		Ω(cache.Len()).Should(Equal(0))
+
+		store.onMatch = nil
+		Ω(authenticate(makeToken("alice", "bob", rsaKey1))).Should(Succeed())
+		Ω(cache.Len()).Should(Equal(1))
+	})
+
+	It("subscribes to the keystore only once", func() {
+		Ω(store.subscribers).Should(Equal(int32(1)))
+		jwtauth.AuthenticateWithOptions(commonScheme, store, jwtauth.Cache(cache))
+		Ω(store.subscribers).Should(Equal(int32(1)))
+
+		cache.Close()
+		Ω(store.subscribers).Should(Equal(int32(0)))
+	})
+})
+
+func benchmarkAuthenticate(b *testing.B, opts ...jwtauth.Option) {
+	store := &jwtauth.NamedKeystore{}
+	if err := store.Trust("alice", rsaKey1); err != nil {
+		b.Fatal(err)
+	}
+	stack := jwtauth.AuthenticateWithOptions(commonScheme, store, opts...)(
+		func(context.Context, http.ResponseWriter, *http.Request) error { return nil })
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	setBearerHeader(req, makeToken("alice", "bob", rsaKey1))
+	resp := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := stack(context.Background(), resp, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAuthenticateRSA(b *testing.B) {
+	benchmarkAuthenticate(b)
+}
+
+func BenchmarkAuthenticateRSACached(b *testing.B) {
+	benchmarkAuthenticate(b, jwtauth.Cache(jwtauth.NewTokenCache(1024)))
+}
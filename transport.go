@@ -0,0 +1,191 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type (
+	// TokenSource supplies the tokens that Transport attaches to outbound
+	// requests. Token may return the empty string, in which case requests
+	// are sent without an Authorization header.
+	TokenSource interface {
+		Token(ctx context.Context) (string, error)
+	}
+
+	// Transport is an http.RoundTripper that authenticates outbound requests
+	// by attaching a bearer token from Source to each of them. Use it to
+	// call other services that are protected by jwtauth:
+	//
+	//     source := jwtauth.NewIssuerTokenSource(myKey, jwtauth.NewClaims("iss", "me"), time.Hour)
+	//     client := &http.Client{Transport: &jwtauth.Transport{Source: source}}
+	//
+	// The request's context is passed to Source, which makes it possible to
+	// relay the token of an inbound request (see NewForwardingTokenSource).
+	Transport struct {
+		// Source supplies tokens.
+		Source TokenSource
+
+		// Base is the RoundTripper that sends requests. If nil,
+		// http.DefaultTransport is used.
+		Base http.RoundTripper
+	}
+
+	staticTokenSource string
+
+	forwardingTokenSource struct{}
+
+	issuerTokenSource struct {
+		key    interface{}
+		claims Claims
+		ttl    time.Duration
+	}
+
+	reuseTokenSource struct {
+		src    TokenSource
+		margin time.Duration
+
+		mu     sync.Mutex
+		token  string
+		expiry time.Time
+	}
+)
+
+// DefaultRefreshMargin is how long before a token expires that
+// NewReuseTokenSource obtains a new one, unless told otherwise.
+const DefaultRefreshMargin = 10 * time.Second
+
+// RoundTrip implements http.RoundTripper#RoundTrip
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Source == nil {
+		return base.RoundTrip(req)
+	}
+
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		// RoundTrip must always close the request body
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if token == "" {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTrip must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(req)
+}
+
+// NewStaticTokenSource creates a TokenSource that always supplies the same
+// token.
+func NewStaticTokenSource(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+func (s staticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// NewForwardingTokenSource creates a TokenSource that relays the token of the
+// inbound request that is being served, as found by ContextToken. It supplies
+// no token if the context has none; pass the context of the inbound request
+// to the outbound one (e.g. using http.NewRequestWithContext) so that the
+// token can be found.
+func NewForwardingTokenSource() TokenSource {
+	return forwardingTokenSource{}
+}
+
+func (forwardingTokenSource) Token(ctx context.Context) (string, error) {
+	return ContextToken(ctx), nil
+}
+
+// NewIssuerTokenSource creates a TokenSource that mints its own tokens using
+// NewToken. Each token contains the specified claims, plus "iat" and an "exp"
+// that is ttl in the future. Tokens are reused until shortly before they
+// expire, as by NewReuseTokenSource.
+func NewIssuerTokenSource(key interface{}, claims Claims, ttl time.Duration) TokenSource {
+	return NewReuseTokenSource(&issuerTokenSource{key: key, claims: claims, ttl: ttl}, 0)
+}
+
+func (s *issuerTokenSource) Token(ctx context.Context) (string, error) {
+	if s.ttl <= 0 {
+		return "", errors.New("token TTL must be positive")
+	}
+	now := time.Now()
+	claims := make(Claims, len(s.claims)+2)
+	for k, v := range s.claims {
+		claims[k] = v
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.ttl).Unix()
+	return NewToken(s.key, claims)
+}
+
+// NewReuseTokenSource creates a TokenSource that caches the tokens supplied by
+// src, and obtains a new one only when the cached token is within margin of
+// its "exp" claim. If margin is zero, DefaultRefreshMargin is used. Tokens
+// whose expiry cannot be determined (because they are not JWTs, or have no
+// "exp" claim) are not reused.
+//
+// The cached token is shared by every caller, whatever its context. Sources
+// whose tokens belong to an inbound request (NewForwardingTokenSource and
+// Delegation) are therefore returned unwrapped, since reusing their tokens
+// would hand one caller's token to others. Do not wrap any other source
+// whose tokens depend on the context.
+//
+// It is safe for concurrent use; when a token needs refreshing, one caller
+// obtains a new token and the others wait for it.
+func NewReuseTokenSource(src TokenSource, margin time.Duration) TokenSource {
+	switch src.(type) {
+	case forwardingTokenSource, *Delegation:
+		return src
+	}
+	if margin == 0 {
+		margin = DefaultRefreshMargin
+	}
+	return &reuseTokenSource{src: src, margin: margin}
+}
+
+func (s *reuseTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(s.margin).Before(s.expiry) {
+		return s.token, nil
+	}
+
+	token, err := s.src.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expiry = token, tokenExpiry(token)
+	return token, nil
+}
+
+// tokenExpiry returns the time at which a JWT expires, without verifying it,
+// or the zero time if this cannot be determined.
+func tokenExpiry(token string) time.Time {
+	if !isJWT(token) {
+		return time.Time{}
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return time.Time{}
+	}
+	if _, ok := claims["exp"]; !ok {
+		return time.Time{}
+	}
+	return Claims(claims).ExpiresAt()
+}
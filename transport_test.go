@@ -0,0 +1,180 @@
+package jwtauth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/jwtauth"
+)
+
+// countingSource is a TokenSource that mints a new token, expiring after ttl,
+// every time it is called.
+type countingSource struct {
+	ttl   time.Duration
+	calls int32
+}
+
+func (cs *countingSource) Token(ctx context.Context) (string, error) {
+	n := atomic.AddInt32(&cs.calls, 1)
+	return jwtauth.NewToken(hmacKey1, jwtauth.NewClaims(
+		"iss", "alice", "jti", fmt.Sprint(n), "exp", time.Now().Add(cs.ttl).Unix(),
+	))
+}
+
+type failingSource struct{}
+
+func (failingSource) Token(ctx context.Context) (string, error) {
+	return "", errors.New("no token for you")
+}
+
+var _ = Describe("Transport", func() {
+	var server *httptest.Server
+	var received []string
+	var mu sync.Mutex
+
+	get := func(source jwtauth.TokenSource, ctx context.Context) error {
+		client := &http.Client{Transport: &jwtauth.Transport{Source: source}}
+		req, err := http.NewRequest("GET", server.URL, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		resp, err := client.Do(req.WithContext(ctx))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	BeforeEach(func() {
+		received = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, r.Header.Get("Authorization"))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("attaches static tokens", func() {
+		Ω(get(jwtauth.NewStaticTokenSource("abc"), context.Background())).Should(Succeed())
+		Ω(received).Should(Equal([]string{"Bearer abc"}))
+	})
+
+	It("forwards the token of the inbound request", func() {
+		source := jwtauth.NewForwardingTokenSource()
+		Ω(get(source, jwtauth.WithToken(context.Background(), "inbound"))).Should(Succeed())
+		Ω(get(source, context.Background())).Should(Succeed())
+		Ω(received).Should(Equal([]string{"Bearer inbound", ""}))
+	})
+
+	It("mints tokens that recipients trust", func() {
+		source := jwtauth.NewIssuerTokenSource(hmacKey1, jwtauth.NewClaims("iss", "alice", "sub", "bob"), time.Hour)
+		Ω(get(source, context.Background())).Should(Succeed())
+		Ω(get(source, context.Background())).Should(Succeed())
+		Ω(received).Should(HaveLen(2))
+		Ω(received[1]).Should(Equal(received[0]))
+
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Authorization", received[0])
+		var claims jwtauth.Claims
+		stack := jwtauth.Authenticate(commonScheme, &jwtauth.SimpleKeystore{Key: hmacKey1})(
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				claims = jwtauth.ContextClaims(ctx)
+				return nil
+			})
+		Ω(stack(context.Background(), httptest.NewRecorder(), req)).Should(Succeed())
+		Ω(claims.Subject()).Should(Equal("bob"))
+		Ω(claims.ExpiresAt()).Should(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+	})
+
+	It("fails when the source fails", func() {
+		Ω(get(failingSource{}, context.Background())).ShouldNot(Succeed())
+		Ω(received).Should(BeEmpty())
+	})
+
+	It("does not modify the caller's request", func() {
+		transport := &jwtauth.Transport{Source: jwtauth.NewStaticTokenSource("abc")}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := transport.RoundTrip(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		resp.Body.Close()
+		Ω(req.Header.Get("Authorization")).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("NewReuseTokenSource()", func() {
+	It("reuses tokens until shortly before they expire", func() {
+		src := &countingSource{ttl: time.Hour}
+		source := jwtauth.NewReuseTokenSource(src, time.Minute)
+		first, err := source.Token(context.Background())
+		Ω(err).ShouldNot(HaveOccurred())
+		second, err := source.Token(context.Background())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(second).Should(Equal(first))
+		Ω(src.calls).Should(Equal(int32(1)))
+
+		src = &countingSource{ttl: time.Minute}
+		source = jwtauth.NewReuseTokenSource(src, 2*time.Minute)
+		first, _ = source.Token(context.Background())
+		second, _ = source.Token(context.Background())
+		Ω(second).ShouldNot(Equal(first))
+		Ω(src.calls).Should(Equal(int32(2)))
+	})
+
+	It("does not reuse tokens with no expiry", func() {
+		source := jwtauth.NewReuseTokenSource(jwtauth.NewStaticTokenSource("opaque"), 0)
+		tok, err := source.Token(context.Background())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tok).Should(Equal("opaque"))
+	})
+
+	It("refreshes once for concurrent callers", func() {
+		src := &countingSource{ttl: time.Hour}
+		source := jwtauth.NewReuseTokenSource(src, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := source.Token(context.Background())
+				Ω(err).ShouldNot(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+		Ω(atomic.LoadInt32(&src.calls)).Should(Equal(int32(1)))
+	})
+
+	It("does not share inbound tokens between callers", func() {
+		inbound := func(sub string) context.Context {
+			ctx := jwtauth.WithToken(context.Background(), makeToken("login", sub, hmacKey1))
+			return jwtauth.WithClaims(ctx, jwtauth.NewClaims("iss", "login", "sub", sub))
+		}
+		sources := []jwtauth.TokenSource{
+			jwtauth.NewForwardingTokenSource(),
+			&jwtauth.Delegation{Key: hmacKey2, Actor: "me"},
+		}
+		for _, src := range sources {
+			source := jwtauth.NewReuseTokenSource(src, 0)
+			first, err := source.Token(inbound("alice"))
+			Ω(err).ShouldNot(HaveOccurred())
+			second, err := source.Token(inbound("bob"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(second).ShouldNot(Equal(first))
+		}
+	})
+
+	It("reports errors", func() {
+		_, err := jwtauth.NewReuseTokenSource(failingSource{}, 0).Token(context.Background())
+		Ω(err).Should(HaveOccurred())
+	})
+})